@@ -2,8 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
-	"net"
 	"time"
 	"zinx/znet"
 )
@@ -17,50 +15,29 @@ func main() {
 	// 3 秒之后发起测试请求，给服务端开启服务的机会
 	time.Sleep(3 * time.Second)
 
-	conn, err := net.Dial("tcp", "127.0.0.1:7777")
-	if err != nil {
-		fmt.Println("client start err, exit!")
+	// 创建客户端, 连接时向服务端请求启用压缩
+	client := znet.NewClient("127.0.0.1:7777")
+	client.Compression = true
+	if err := client.Start(); err != nil {
+		fmt.Println("client start err, exit!", err)
 		return
 	}
+	defer client.Stop()
 
 	for {
 		//发封包message消息
-		dp := znet.NewDataPack()
-		msg, _ := dp.Pack(znet.NewMsgPackage(0, []byte("Zinx V0.5 Client Test Message")))
-		_, err := conn.Write(msg)
-		if err != nil {
+		if err := client.SendMsg(0, []byte("Zinx V0.5 Client Test Message")); err != nil {
 			fmt.Println("write error err ", err)
 			return
 		}
 
-		//先读出流中的head部分
-		headData := make([]byte, dp.GetHeadLen())
-		_, err = io.ReadFull(conn, headData) //ReadFull 会把msg填充满为止
-		if err != nil {
-			fmt.Println("read head error")
-			break
-		}
-		//将headData字节流 拆包到msg中
-		msgHead, err := dp.Unpack(headData)
+		//读取服务端的回复, 压缩过的消息已经被解压
+		msg, err := client.ReadMsg()
 		if err != nil {
-			fmt.Println("server unpack err:", err)
+			fmt.Println("read msg error", err)
 			return
 		}
-
-		if msgHead.GetDataLen() > 0 {
-			//msg 是有data数据的，需要再次读取data数据
-			msg := msgHead.(*znet.Message)
-			msg.Data = make([]byte, msg.GetDataLen())
-
-			//根据dataLen从io中读取字节流
-			_, err := io.ReadFull(conn, msg.Data)
-			if err != nil {
-				fmt.Println("server unpack data err:", err)
-				return
-			}
-
-			fmt.Println("==> Recv Msg: ID=", msg.Id, ", len=", msg.DataLen, ", data=", string(msg.Data))
-		}
+		fmt.Println("==> Recv Msg: ID=", msg.GetMsgId(), ", len=", msg.GetDataLen(), ", data=", string(msg.GetData()))
 
 		time.Sleep(1 * time.Second)
 	}
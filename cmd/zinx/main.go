@@ -0,0 +1,59 @@
+// zinx 为 zinx 的命令行工具
+//
+//	zinx gen [-o 输出文件] <协议描述文件>
+//
+// gen 根据协议描述文件生成消息 ID 常量, 类型化的发送函数, Router 与客户端方法,
+// 默认输出到与协议描述文件同名的 _zinx.go 文件中. 协议描述的格式见 zinx/zgen.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"zinx/zgen"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "gen" {
+		fmt.Fprintln(os.Stderr, "usage: zinx gen [-o output] <schema>")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	output := fs.String("o", "", "输出文件, 默认为 <schema>_zinx.go")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: zinx gen [-o output] <schema>")
+		os.Exit(2)
+	}
+
+	if err := gen(fs.Arg(0), *output); err != nil {
+		fmt.Fprintln(os.Stderr, "zinx gen:", err)
+		os.Exit(1)
+	}
+}
+
+// gen 解析协议描述文件 source 并把生成的代码写入 output
+func gen(source, output string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	schema, err := zgen.Parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	code, err := zgen.Generate(schema, filepath.Base(source))
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = strings.TrimSuffix(source, filepath.Ext(source)) + "_zinx.go"
+	}
+	return os.WriteFile(output, code, 0o644)
+}
@@ -2,10 +2,12 @@ module zinx
 
 go 1.23.1
 
-require github.com/spf13/viper v1.19.0
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/spf13/viper v1.19.0
+)
 
 require (
-	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
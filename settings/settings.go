@@ -1,9 +1,12 @@
 package settings
 
 import (
-	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
 )
 
 type ZinxConfig struct {
@@ -14,12 +17,122 @@ type ZinxConfig struct {
 	MaxPacketSize    uint32 `mapstructure:"max_packet_size"`
 	MaxConn          int    `mapstructure:"max_conn"`
 	WorkerPoolSize   uint32 `mapstructure:"worker_pool_size"`
+	DispatchMode     string `mapstructure:"dispatch_mode"` // 消息分发模式: hash (按 ConnID 固定 worker), ordered (连接串行队列 + 共享工作池), tick (按固定频率批量处理)
 	MaxWorkerTaskLen uint32 `mapstructure:"max_worker_task_len"`
+	StarvationLimit  int    `mapstructure:"starvation_limit"` // 连续优先处理高优先级消息的最大次数, 超过后先处理一条低优先级消息, 0 表示使用默认值
 	MaxMsgChanLen    uint32 `mapstructure:"max_msg_chan_len"`
+
+	SendOverflowPolicy string        `mapstructure:"send_overflow_policy"` // 缓冲发送队列已满时的策略: block, drop_newest, drop_oldest, disconnect
+	MaxWriteBatch      uint32        `mapstructure:"max_write_batch"`      // 写 goroutine 单次合并写出的最大消息数
+	WriteFlushLatency  time.Duration `mapstructure:"write_flush_latency"`  // 批次未满时最多等待的时长, 0 表示不等待
+
+	Compression       bool   `mapstructure:"compression"`        // 是否允许客户端协商启用消息压缩
+	CompressThreshold uint32 `mapstructure:"compress_threshold"` // 消息数据超过该长度才压缩
+
+	Secure    bool   `mapstructure:"secure"`     // 是否要求客户端在连接建立后进行安全通道握手
+	SecurePSK string `mapstructure:"secure_psk"` // 可选的预共享密钥, 参与密钥派生, 用于防御中间人
+
+	AuthTimeout time.Duration `mapstructure:"auth_timeout"` // 设置了认证方法时, 连接须在该时长内完成登录
+
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"` // 入站消息限速
+
+	Backpressure BackpressureConfig `mapstructure:"backpressure"` // 任务队列已满时的背压策略
+
+	IPAllow      []string `mapstructure:"ip_allow"`        // 允许连接的 CIDR 或 IP, 非空时只接受列表中的地址
+	IPDeny       []string `mapstructure:"ip_deny"`         // 拒绝连接的 CIDR 或 IP, 优先于 ip_allow
+	MaxConnPerIP int      `mapstructure:"max_conn_per_ip"` // 每个来源 IP 的最大并发连接数, 0 表示不限制
+
+	ProxyProtocol ProxyProtocolConfig `mapstructure:"proxy_protocol"` // 部署在 L4 负载均衡之后时的 PROXY protocol 配置
+
+	LogLevel  string `mapstructure:"log_level"`  // 日志级别: debug, info, warn, error
+	LogFormat string `mapstructure:"log_format"` // 日志格式: text, json
+
+	MetricsAddr string      `mapstructure:"metrics_addr"` // Prometheus 指标 HTTP 服务的监听地址, 如 127.0.0.1:9100, 为空时不启动
+	Admin       AdminConfig `mapstructure:"admin"`        // 管理后台配置
+
+	Codec      string `mapstructure:"codec"`        // 类型化路由默认的编解码器: json, protobuf, msgpack
+	ErrorMsgId uint32 `mapstructure:"error_msg_id"` // 类型化路由出错时回复错误信息使用的消息 ID, 为 0 时不回复
+
+	SlowHandlerThreshold time.Duration `mapstructure:"slow_handler_threshold"` // 处理链耗时超过该值时记录慢处理警告, 0 表示不检测
+	TimeoutMsgId         uint32        `mapstructure:"timeout_msg_id"`         // 路由处理超时时回复的消息 ID, 数据为超时消息的 msgId, 为 0 时不回复
+
+	Scheduler SchedulerConfig `mapstructure:"scheduler"` // 定时任务调度器的时间轮配置
+	Tick      TickConfig      `mapstructure:"tick"`      // dispatch_mode 为 tick 时的游戏循环配置
+}
+
+// ProxyProtocolConfig 为 PROXY protocol v1/v2 的配置
+type ProxyProtocolConfig struct {
+	Enable         bool          `mapstructure:"enable"`          // 是否解析 PROXY 头
+	TrustedProxies []string      `mapstructure:"trusted_proxies"` // 受信代理的 CIDR 或 IP, 只解析来自这些地址的 PROXY 头
+	AllowDirect    bool          `mapstructure:"allow_direct"`    // 是否允许非受信来源直接连接, 其 PROXY 头不会被解析
+	HeaderTimeout  time.Duration `mapstructure:"header_timeout"`  // 读取 PROXY 头的超时时间, 默认 5s
+}
+
+// AdminConfig 为管理后台的配置
+type AdminConfig struct {
+	Addr  string `mapstructure:"addr"`  // 管理后台 HTTP 服务的监听地址, 为空时不启动
+	Token string `mapstructure:"token"` // 访问令牌, 为空时管理后台拒绝启动
+}
+
+// RateLimitConfig 为入站消息的令牌桶限速配置, rate 为每秒补充的令牌数, 为 0 表示不限制
+type RateLimitConfig struct {
+	GlobalRate    float64         `mapstructure:"global_rate"` // 整个 Server 共享
+	GlobalBurst   int             `mapstructure:"global_burst"`
+	IPRate        float64         `mapstructure:"ip_rate"` // 同一远程 IP 的全部连接共享
+	IPBurst       int             `mapstructure:"ip_burst"`
+	ConnRate      float64         `mapstructure:"conn_rate"` // 每个连接独享
+	ConnBurst     int             `mapstructure:"conn_burst"`
+	MsgRates      []MsgRateConfig `mapstructure:"msg_rates"`       // 每个连接上按 msgId 独享
+	Policy        string          `mapstructure:"policy"`          // 超出限制时的策略: drop, delay, throttle, disconnect
+	ThrottleMsgId uint32          `mapstructure:"throttle_msg_id"` // throttle 策略回复的 msgId
+}
+
+// BackpressureConfig 为 worker 任务队列已满时的背压配置
+type BackpressureConfig struct {
+	Policy    string `mapstructure:"policy"`      // 队列已满时的策略: pause, drop, busy, disconnect
+	BusyMsgId uint32 `mapstructure:"busy_msg_id"` // busy 策略回复的 msgId
+}
+
+// SchedulerConfig 为定时任务调度器的时间轮配置, 一圈的时长为 tick * wheel_size, 更长的延迟按圈数计算
+type SchedulerConfig struct {
+	Tick      time.Duration `mapstructure:"tick"`       // 时间轮前进一格的时长, 即定时任务的精度, 默认 10ms
+	WheelSize int           `mapstructure:"wheel_size"` // 时间轮的格数, 默认 512
+}
+
+// TickConfig 为 tick 模式的配置
+type TickConfig struct {
+	Interval  time.Duration `mapstructure:"interval"`   // tick 的间隔, 默认 50ms
+	MaxBucket int           `mapstructure:"max_bucket"` // 每个 tick 最多收集的消息数, 超出时按背压策略处理, 0 表示不限制
+}
+
+// MsgRateConfig 为单个 msgId 的限速配置
+type MsgRateConfig struct {
+	MsgId uint32  `mapstructure:"msg_id"`
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
 }
 
 var Conf = new(ZinxConfig)
 
+var (
+	changeHooks []*func() // 配置文件热更新后的回调, 以指针区分以便注销
+	hooksLock   sync.Mutex
+)
+
+// OnChange 注册配置文件热更新后的回调, 回调在新配置反序列化到 Conf 之后调用. 返回注销该回调的方法
+func OnChange(hook func()) (unregister func()) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	h := &hook
+	changeHooks = append(changeHooks, h)
+	return func() {
+		hooksLock.Lock()
+		defer hooksLock.Unlock()
+		changeHooks = slices.DeleteFunc(changeHooks, func(other *func()) bool { return other == h })
+	}
+}
+
 func Init() (err error) {
 
 	viper.SetConfigFile("../conf/config.yaml")
@@ -28,18 +141,26 @@ func Init() (err error) {
 	err = viper.ReadInConfig() // 读取配置信息
 	if err != nil {
 		// 读取配置信息失败
-		fmt.Printf("viper.ReadInConfig() failed, err:%v\n", err)
+		slog.Error("viper.ReadInConfig() failed", "err", err)
 		return
 	}
 	// 把读取到的配置信息反序列化到 Conf 变量中
 	if err = viper.Unmarshal(Conf); err != nil {
-		fmt.Printf("viper.Unmarshal failed, err:%v\n", err)
+		slog.Error("viper.Unmarshal failed", "err", err)
 	}
 	viper.WatchConfig()
 	viper.OnConfigChange(func(in fsnotify.Event) {
-		fmt.Println("配置文件修改了...")
+		slog.Info("配置文件修改了", "file", in.Name)
 		if err = viper.Unmarshal(Conf); err != nil {
-			fmt.Printf("viper.Unmarshal failed, err:%v\n", err)
+			slog.Error("viper.Unmarshal failed", "err", err)
+			return
+		}
+
+		hooksLock.Lock()
+		hooks := slices.Clone(changeHooks)
+		hooksLock.Unlock()
+		for _, hook := range hooks {
+			(*hook)()
 		}
 	})
 	return
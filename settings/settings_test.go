@@ -0,0 +1,18 @@
+package settings
+
+import "testing"
+
+func TestOnChangeUnregister(t *testing.T) {
+	before := len(changeHooks)
+	first := OnChange(func() {})
+	second := OnChange(func() {})
+	first()
+	first()
+	if n := len(changeHooks); n != before+1 {
+		t.Fatalf("hooks after unregister = %d, want %d", n, before+1)
+	}
+	second()
+	if n := len(changeHooks); n != before {
+		t.Fatalf("hooks = %d, want %d", n, before)
+	}
+}
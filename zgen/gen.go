@@ -0,0 +1,139 @@
+package zgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Generate 根据协议描述生成 Go 代码, source 为协议描述文件名, 写入生成代码的注释中
+func Generate(schema *Schema, source string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, struct {
+		*Schema
+		Source string
+	}{schema, source}); err != nil {
+		return nil, err
+	}
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return out, nil
+}
+
+// jsonName 将字段名转换为 JSON 中使用的 snake_case 名称, 如 PlayerID -> player_id
+func jsonName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// 连续大写字母视为一个缩写, 只在缩写的开头与结尾断词
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var fileTemplate = template.Must(template.New("file").Funcs(template.FuncMap{
+	"jsonName": jsonName,
+}).Parse(`// Code generated by zinx gen. DO NOT EDIT.
+// source: {{.Source}}
+
+package {{.Package}}
+
+import (
+{{- if .Messages}}
+	"zinx/ziface"
+{{- end}}
+	"zinx/znet"
+)
+
+// 消息 ID
+const (
+{{- range .Messages}}
+	Msg{{.Name}} uint32 = {{.MsgId}} // {{.Direction}} {{.Payload}}
+{{- end}}
+)
+{{range .Types}}
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`json:\"{{jsonName .Name}}\"`" + `
+{{- end}}
+}
+{{end}}
+{{- range .Messages}}{{if eq .Direction "c2s"}}
+// {{.Name}}Router 为 Msg{{.Name}} 的 Router, {{if .Raw}}消息数据{{else}}解码后的消息{{end}}交给 On{{.Name}} 处理
+type {{.Name}}Router struct {
+	znet.BaseRouter
+{{- if .Raw}}
+	On{{.Name}} func(request ziface.IRequest, data []byte)
+{{- else}}
+	On{{.Name}} func(request ziface.IRequest, msg *{{.Payload}})
+{{- end}}
+}
+
+func (r *{{.Name}}Router) Handle(request ziface.IRequest) {
+	if r.On{{.Name}} == nil {
+		return
+	}
+{{- if .Raw}}
+	r.On{{.Name}}(request, request.GetData())
+{{- else}}
+	msg := new({{.Payload}})
+	if err := znet.DecodeRequest(request, msg); err != nil {
+		return
+	}
+	r.On{{.Name}}(request, msg)
+{{- end}}
+}
+{{else}}
+// Send{{.Name}} 向连接发送 Msg{{.Name}}
+{{- if .Raw}}
+func Send{{.Name}}(conn ziface.IConnection, data []byte) error {
+	return conn.SendBuffMsg(Msg{{.Name}}, data)
+}
+{{- else}}
+func Send{{.Name}}(conn ziface.IConnection, msg *{{.Payload}}) error {
+	return znet.SendTyped(conn, Msg{{.Name}}, msg)
+}
+{{- end}}
+{{end}}{{end}}
+// Client 为带有类型化方法的 zinx 客户端
+type Client struct {
+	*znet.Client
+}
+
+// NewClient 创建连接 addr 的客户端
+func NewClient(addr string) *Client {
+	return &Client{Client: znet.NewClient(addr)}
+}
+{{range .Messages}}{{if eq .Direction "c2s"}}
+// {{.Name}} 向服务端发送 Msg{{.Name}}
+{{- if .Raw}}
+func (c *Client) {{.Name}}(data []byte) error {
+	return c.SendMsg(Msg{{.Name}}, data)
+}
+{{- else}}
+func (c *Client) {{.Name}}(msg *{{.Payload}}) error {
+	return c.SendTyped(Msg{{.Name}}, msg)
+}
+{{- end}}
+{{else if not .Raw}}
+// Decode{{.Name}} 解码服务端发来的 Msg{{.Name}}
+func (c *Client) Decode{{.Name}}(msg ziface.IMessage) (*{{.Payload}}, error) {
+	v := new({{.Payload}})
+	if err := c.Codec.Unmarshal(msg.GetData(), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+{{end}}{{end}}`))
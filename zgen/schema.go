@@ -0,0 +1,304 @@
+// Package zgen 解析 zinx 协议描述文件, 并生成消息 ID 常量, 类型化的发送函数, Router 与客户端方法.
+//
+// 协议描述文件按行书写, # 或 // 开头的行为注释:
+//
+//	package game
+//
+//	type LoginReq {
+//	    Name     string
+//	    Password string
+//	}
+//
+//	# message <名称> <msgId> <方向 c2s|s2c> <消息数据类型, - 表示原始字节>
+//	message Login     1001 c2s LoginReq
+//	message LoginAck  1002 s2c LoginResp
+//	message Heartbeat 1    c2s -
+//
+// 字段类型只能由 Go 预声明类型与协议中声明的类型组成, 可以是切片, 数组, map 或指针;
+// 消息数据类型须为协议中声明的类型.
+//
+// 每条消息生成以下标识符, 类型与消息的名称不能使它们相互冲突, 也不能与 znet.Client 已有的方法或导出字段同名:
+//
+//	Msg<Name>                       消息 ID 常量
+//	<Name>Router                    c2s 消息的 Router
+//	Send<Name>(conn, msg)           s2c 消息的发送函数
+//	(*Client).<Name>(msg)           c2s 消息的客户端发送方法
+//	(*Client).Decode<Name>(msg)     s2c 消息的客户端解码方法
+//
+// 服务端的 Send<Name> 以连接为第一个参数, 而不是连接的方法: 连接类型 ziface.IConnection 定义在 zinx 中,
+// 生成的代码无法为其添加方法
+package zgen
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 消息方向
+const (
+	ClientToServer = "c2s" // 客户端发往服务端
+	ServerToClient = "s2c" // 服务端发往客户端
+)
+
+// RawPayload 表示消息数据不经过编解码器, 直接使用原始字节
+const RawPayload = "-"
+
+// reservedMsgId 为 znet 保留的特性协商消息 ID
+const reservedMsgId = 0xFFFFFFFF
+
+// generatedNames 为生成代码固定使用的包级标识符
+var generatedNames = []string{"Client", "NewClient", "ziface", "znet"}
+
+// clientMembers 为 znet.Client 已有的方法与导出字段, 以及内嵌字段 Client 本身, 生成的 Client 方法不能与之同名
+var clientMembers = []string{
+	"Client", "Start", "Stop", "GetConn", "SendMsg", "SendMsgContext", "SendTyped", "ReadMsg",
+	"Addr", "Conn", "Compression", "CompressThreshold", "Secure", "SecurePSK", "TraceContext", "Codec", "Logger",
+}
+
+// Schema 为解析后的协议描述
+type Schema struct {
+	Package  string
+	Types    []*Type
+	Messages []*Message
+}
+
+// Type 为协议中声明的消息数据类型, 生成为 Go 结构体
+type Type struct {
+	Name   string
+	Fields []*Field
+	Line   int
+}
+
+// Field 为消息数据类型的字段
+type Field struct {
+	Name string
+	Type string // Go 类型, 如 string, []int32, 只能由 Go 预声明类型与协议中声明的类型组成
+	Line int
+}
+
+// Message 为协议中声明的消息
+type Message struct {
+	Name      string
+	MsgId     uint32
+	Direction string // ClientToServer 或 ServerToClient
+	Payload   string // 消息数据类型, RawPayload 表示原始字节
+	Line      int
+}
+
+// Raw 判断消息数据是否为原始字节
+func (m *Message) Raw() bool {
+	return m.Payload == RawPayload
+}
+
+// Parse 解析协议描述, 语法错误, 名称重复或 msgId 冲突时返回错误
+func Parse(r io.Reader) (*Schema, error) {
+	schema := &Schema{}
+	var current *Type // 正在解析的 type 块
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//") {
+			continue
+		}
+		fields := strings.Fields(text)
+
+		if current != nil {
+			if text == "}" {
+				current = nil
+				continue
+			}
+			if len(fields) != 2 || !token.IsIdentifier(fields[0]) {
+				return nil, fmt.Errorf("line %d: invalid field %q, want <Name> <Type>", line, text)
+			}
+			current.Fields = append(current.Fields, &Field{Name: fields[0], Type: fields[1], Line: line})
+			continue
+		}
+
+		switch fields[0] {
+		case "package":
+			if len(fields) != 2 || !token.IsIdentifier(fields[1]) {
+				return nil, fmt.Errorf("line %d: invalid package %q", line, text)
+			}
+			schema.Package = fields[1]
+		case "type":
+			if len(fields) != 3 || fields[2] != "{" || !token.IsIdentifier(fields[1]) {
+				return nil, fmt.Errorf("line %d: invalid type %q, want type <Name> {", line, text)
+			}
+			current = &Type{Name: fields[1], Line: line}
+			schema.Types = append(schema.Types, current)
+		case "message":
+			msg, err := parseMessage(fields, line)
+			if err != nil {
+				return nil, err
+			}
+			schema.Messages = append(schema.Messages, msg)
+		default:
+			return nil, fmt.Errorf("line %d: unknown statement %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("line %d: type %s is not closed", current.Line, current.Name)
+	}
+	if schema.Package == "" {
+		return nil, fmt.Errorf("missing package statement")
+	}
+	return schema, schema.validate()
+}
+
+// parseMessage 解析一行 message 语句
+func parseMessage(fields []string, line int) (*Message, error) {
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("line %d: invalid message, want message <Name> <msgId> <c2s|s2c> <Payload>", line)
+	}
+	msg := &Message{Name: fields[1], Direction: fields[3], Payload: fields[4], Line: line}
+	if !token.IsIdentifier(msg.Name) {
+		return nil, fmt.Errorf("line %d: invalid message name %q", line, msg.Name)
+	}
+	id, err := strconv.ParseUint(fields[2], 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: invalid msgId %q", line, fields[2])
+	}
+	if id == reservedMsgId {
+		return nil, fmt.Errorf("line %d: msgId %#x is reserved", line, id)
+	}
+	msg.MsgId = uint32(id)
+	if msg.Direction != ClientToServer && msg.Direction != ServerToClient {
+		return nil, fmt.Errorf("line %d: invalid direction %q, want c2s or s2c", line, msg.Direction)
+	}
+	if !msg.Raw() && !token.IsIdentifier(msg.Payload) {
+		return nil, fmt.Errorf("line %d: invalid payload type %q", line, msg.Payload)
+	}
+	return msg, nil
+}
+
+// validate 检查名称重复, msgId 冲突, 未声明的类型以及生成代码中的标识符冲突
+func (s *Schema) validate() error {
+	names := make(map[string]int)
+	for _, t := range s.Types {
+		if prev, ok := names[t.Name]; ok {
+			return fmt.Errorf("line %d: type %s already declared at line %d", t.Line, t.Name, prev)
+		}
+		names[t.Name] = t.Line
+	}
+	for _, t := range s.Types {
+		fieldNames := make(map[string]int)
+		for _, f := range t.Fields {
+			if prev, ok := fieldNames[f.Name]; ok {
+				return fmt.Errorf("line %d: field %s.%s already declared at line %d", f.Line, t.Name, f.Name, prev)
+			}
+			fieldNames[f.Name] = f.Line
+			expr, err := parser.ParseExpr(f.Type)
+			if err != nil || !declaredType(expr, names) {
+				return fmt.Errorf("line %d: field %s.%s has undeclared type %q", f.Line, t.Name, f.Name, f.Type)
+			}
+		}
+	}
+
+	msgNames := make(map[string]int)
+	ids := make(map[uint32]*Message)
+	for _, m := range s.Messages {
+		if prev, ok := msgNames[m.Name]; ok {
+			return fmt.Errorf("line %d: message %s already declared at line %d", m.Line, m.Name, prev)
+		}
+		msgNames[m.Name] = m.Line
+		if _, ok := names[m.Payload]; !m.Raw() && !ok {
+			return fmt.Errorf("line %d: payload type %s of %s is not declared", m.Line, m.Payload, m.Name)
+		}
+		if prev, ok := ids[m.MsgId]; ok {
+			return fmt.Errorf("line %d: msgId %d of %s collides with %s at line %d",
+				m.Line, m.MsgId, m.Name, prev.Name, prev.Line)
+		}
+		ids[m.MsgId] = m
+	}
+	return s.checkGeneratedNames()
+}
+
+// scope 记录生成代码的一个作用域中已经使用的标识符及其来源
+type scope map[string]string
+
+// declare 在作用域中使用 name, desc 描述其来源; name 已被使用时返回错误
+func (sc scope) declare(name, desc string, line int) error {
+	if prev, ok := sc[name]; ok {
+		return fmt.Errorf("line %d: %s clashes with %s", line, desc, prev)
+	}
+	sc[name] = fmt.Sprintf("%s at line %d", desc, line)
+	return nil
+}
+
+// checkGeneratedNames 检查生成的包级标识符与 Client 方法是否相互冲突或与已有的标识符冲突
+func (s *Schema) checkGeneratedNames() error {
+	pkg, client := make(scope), make(scope)
+	for _, name := range generatedNames {
+		pkg[name] = "generated " + name
+	}
+	for _, name := range clientMembers {
+		client[name] = "znet.Client." + name
+	}
+
+	for _, t := range s.Types {
+		if err := pkg.declare(t.Name, "type "+t.Name, t.Line); err != nil {
+			return err
+		}
+	}
+	for _, m := range s.Messages {
+		decls, methods := m.generated()
+		for _, name := range decls {
+			if err := pkg.declare(name, name+" of message "+m.Name, m.Line); err != nil {
+				return err
+			}
+		}
+		for _, name := range methods {
+			if err := client.declare(name, "Client."+name+" of message "+m.Name, m.Line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generated 返回消息在生成代码中使用的包级标识符与 Client 方法名
+func (m *Message) generated() (decls, methods []string) {
+	decls = []string{"Msg" + m.Name}
+	if m.Direction == ClientToServer {
+		return append(decls, m.Name+"Router"), []string{m.Name}
+	}
+	decls = append(decls, "Send"+m.Name)
+	if !m.Raw() {
+		methods = append(methods, "Decode"+m.Name)
+	}
+	return decls, methods
+}
+
+// declaredType 判断类型表达式是否只由 Go 预声明类型与协议中声明的类型组成, 支持切片, 数组, map 与指针
+func declaredType(expr ast.Expr, names map[string]int) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if _, ok := names[e.Name]; ok {
+			return true
+		}
+		_, ok := types.Universe.Lookup(e.Name).(*types.TypeName)
+		return ok && e.Name != "comparable"
+	case *ast.ArrayType:
+		if _, ok := e.Len.(*ast.BasicLit); e.Len != nil && !ok {
+			return false
+		}
+		return declaredType(e.Elt, names)
+	case *ast.MapType:
+		return declaredType(e.Key, names) && declaredType(e.Value, names)
+	case *ast.StarExpr:
+		return declaredType(e.X, names)
+	default:
+		return false
+	}
+}
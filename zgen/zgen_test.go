@@ -0,0 +1,151 @@
+package zgen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"zinx/znet"
+)
+
+// typeCheck 对生成的代码做类型检查, 依赖包的导出数据由 go list -export 取得
+func typeCheck(t *testing.T, code []byte) {
+	t.Helper()
+	out, err := exec.Command("go", "list", "-export", "-deps", "-f", "{{.ImportPath}}={{.Export}}", "zinx/znet").Output()
+	if err != nil {
+		t.Fatalf("go list: %v", err)
+	}
+	exports := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if path, file, ok := strings.Cut(line, "="); ok && file != "" {
+			exports[path] = file
+		}
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "gen.go", code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		file, ok := exports[path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return os.Open(file)
+	})}
+	if _, err := conf.Check(f.Name.Name, fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s", err, code)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	f, err := os.Open("testdata/game.zinx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	schema, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := Generate(schema, "game.zinx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, code)
+	for _, want := range []string{
+		"MsgLogin     uint32 = 1001",
+		"PlayerID int64  `json:\"player_id\"`",
+		"func SendLoginAck(conn ziface.IConnection, msg *LoginResp) error",
+		"type HeartbeatRouter struct",
+		"func (c *Client) Login(msg *LoginReq) error",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateTypesOnly(t *testing.T) {
+	// 没有消息时不使用 ziface, 也不能导入它
+	schema, err := Parse(strings.NewReader("package p\ntype Pos {\n X float32\n Tags map[string][]*Pos\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := Generate(schema, "p.zinx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(code, []byte("zinx/ziface")) {
+		t.Error("unused ziface import generated")
+	}
+	typeCheck(t, code)
+}
+
+func TestParseErrors(t *testing.T) {
+	for schema, want := range map[string]string{
+		"package p\nmessage A 1 c2s -\nmessage B 1 s2c -\n":                    "msgId 1 of B collides with A at line 2",
+		"package p\nmessage A 1 c2s -\nmessage A 2 s2c -\n":                    "message A already declared",
+		"package p\nmessage A 0xFFFFFFFF c2s -\n":                              "reserved",
+		"package p\nmessage A 1 up -\n":                                        "invalid direction",
+		"package p\ntype T {\n X int\n":                                        "not closed",
+		"message A 1 c2s -\n":                                                  "missing package",
+		"package p\nmessage A 1 c2s B\n":                                       "payload type B of A is not declared",
+		"package p\ntype T {\n X Foo\n}\n":                                     "field T.X has undeclared type",
+		"package p\ntype T {\n X []time.Time\n}\n":                             "field T.X has undeclared type",
+		"package p\ntype T {\n X func()\n}\n":                                  "field T.X has undeclared type",
+		"package p\ntype T {\n X int\n X string\n}\n":                          "field T.X already declared at line 3",
+		"package p\ntype Client {\n}\n":                                        "type Client clashes with generated Client",
+		"package p\ntype znet {\n}\n":                                          "type znet clashes with generated znet",
+		"package p\nmessage SendMsg 1 c2s -\n":                                 "Client.SendMsg of message SendMsg clashes with znet.Client.SendMsg",
+		"package p\nmessage Start 1 c2s -\n":                                   "Client.Start of message Start clashes with znet.Client.Start",
+		"package p\nmessage Codec 1 c2s -\n":                                   "Client.Codec of message Codec clashes with znet.Client.Codec",
+		"package p\ntype LoginRouter {\n}\nmessage Login 1 c2s -\n":            "LoginRouter of message Login clashes with type LoginRouter",
+		"package p\ntype MsgA {\n}\nmessage A 1 s2c -\n":                       "MsgA of message A clashes with type MsgA",
+		"package p\ntype SendA {\n}\nmessage A 1 s2c -\n":                      "SendA of message A clashes with type SendA",
+		"package p\ntype T {\n}\nmessage DecodeA 1 c2s T\nmessage A 2 s2c T\n": "Client.DecodeA of message A clashes with Client.DecodeA of message DecodeA",
+	} {
+		_, err := Parse(strings.NewReader(schema))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) error = %v, want %q", schema, err, want)
+		}
+	}
+}
+
+func TestClientMembers(t *testing.T) {
+	// clientMembers 须包含 znet.Client 全部导出的方法与字段
+	typ := reflect.TypeOf(&znet.Client{})
+	var members []string
+	for i := range typ.NumMethod() {
+		members = append(members, typ.Method(i).Name)
+	}
+	for _, f := range reflect.VisibleFields(typ.Elem()) {
+		if f.IsExported() {
+			members = append(members, f.Name)
+		}
+	}
+	for _, name := range members {
+		if !slices.Contains(clientMembers, name) {
+			t.Errorf("znet.Client.%s missing from clientMembers", name)
+		}
+	}
+}
+
+func TestJSONName(t *testing.T) {
+	for name, want := range map[string]string{"PlayerID": "player_id", "HTTPCode": "http_code", "Name": "name"} {
+		if got := jsonName(name); got != want {
+			t.Errorf("jsonName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package ziface
+
+// IIdentity 为连接通过认证后附加的身份信息
+type IIdentity interface {
+	GetID() string      // 获取身份的唯一标识, 如用户 id
+	GetRoles() []string // 获取身份具备的角色
+}
+
+// Authenticator 校验登录请求中的凭证, 成功时返回该连接的身份
+type Authenticator func(request IRequest) (IIdentity, error)
@@ -0,0 +1,16 @@
+package ziface
+
+import (
+	"context"
+	"net"
+)
+
+// IClient 为 zinx 客户端接口, 按照 DataPack 协议与服务端收发消息
+type IClient interface {
+	Start() error                                                        // 连接服务端并完成特性协商
+	Stop()                                                               // 断开与服务端的连接
+	SendMsg(msgId uint32, data []byte) error                             // 向服务端发送消息
+	SendMsgContext(ctx context.Context, msgId uint32, data []byte) error // 发送消息, 并携带 ctx 中的追踪上下文
+	ReadMsg() (IMessage, error)                                          // 阻塞地读取服务端发来的一条消息
+	GetConn() net.Conn                                                   // 获取底层的网络连接
+}
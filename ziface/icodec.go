@@ -0,0 +1,11 @@
+package ziface
+
+// ICodec 为消息数据的编解码器, 用于在消息数据与 Go 类型之间转换
+type ICodec interface {
+	Name() string                       // 编解码器的名称, 如 json, protobuf
+	Marshal(v any) ([]byte, error)      // 将 v 编码为消息数据
+	Unmarshal(data []byte, v any) error // 将消息数据解码到 v 中
+}
+
+// ErrorHandler 处理类型化路由中的解码错误与业务错误, 通常向客户端回复错误消息
+type ErrorHandler func(request IRequest, err error)
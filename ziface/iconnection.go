@@ -1,6 +1,20 @@
 package ziface
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// OverflowPolicy 定义了连接的缓冲发送队列已满时 SendBuffMsg 的处理策略
+type OverflowPolicy int32
+
+const (
+	OverflowBlock      OverflowPolicy = iota // 阻塞等待队列空出位置 (默认)
+	OverflowDropNewest                       // 丢弃本次要发送的消息
+	OverflowDropOldest                       // 丢弃队列中最旧的消息, 为本次消息腾出位置
+	OverflowDisconnect                       // 认为对端消费过慢, 直接断开连接
+)
 
 type IConnection interface {
 	Start()                                      // 启动连接
@@ -8,12 +22,22 @@ type IConnection interface {
 	GetConnID() uint32                           // 获取远程客户端地址信息
 	GetTCPConnection() *net.TCPConn              // 从当前连接获取原始的 socket TCPConn
 	RemoteAddr() net.Addr                        // 获取远程客户端地址信息
+	ProxyAddr() net.Addr                         // 获取代理地址, 经过 PROXY protocol 时有效, 直连时为 nil
+	Context() context.Context                    // 获取连接的 context, 连接 Stop 时会被取消
 	SendMsg(msgId uint32, data []byte) error     // 直接将 Message 数据发给远程的 TCP 客户端
 	SendBuffMsg(msgId uint32, data []byte) error // 添加带缓冲的发送消息接口
 
+	SendMsgTimeout(msgId uint32, data []byte, timeout time.Duration) error // 在 timeout 内将消息交给写 goroutine, 超时返回错误
+	TrySendBuffMsg(msgId uint32, data []byte) error                        // 非阻塞地写入缓冲队列, 队列已满时立即返回错误
+	SetOverflowPolicy(policy OverflowPolicy)                               // 设置缓冲队列已满时 SendBuffMsg 的处理策略
+	GetDroppedMsgCount() uint64                                            // 获取因缓冲队列已满而被丢弃的消息数量
+
 	SetProperty(key string, value interface{})   // 设置连接属性
 	GetProperty(key string) (interface{}, error) // 获取连接属性
 	RemoveProperty(key string)                   // 移除连接属性
+
+	SetIdentity(identity IIdentity) // 为连接附加认证后的身份
+	GetIdentity() IIdentity         // 获取连接的身份, 未认证时为 nil
 }
 
 // HandFunc 定义了一个统一处理连接业务的接口
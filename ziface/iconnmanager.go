@@ -5,5 +5,8 @@ type IConnManager interface {
 	Remove(conn IConnection)                // 删除连接
 	Get(connID uint32) (IConnection, error) // 利用 ConnID 获取连接
 	Len() int                               // 获取当前连接数量
+	LenByIP(ip string) int                  // 获取来自某个远程 IP 的连接数量
+	GetAll() []IConnection                  // 获取当前全部连接的快照
 	ClearConn()                             // 删除并停止所有连接
+	SetLogger(logger ILogger)               // 设置日志
 }
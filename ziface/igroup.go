@@ -0,0 +1,15 @@
+package ziface
+
+// HandlerFunc 为处理一条请求的方法
+type HandlerFunc func(request IRequest)
+
+// Middleware 为路由分组的中间件, 包装 next 并返回新的处理方法; 不调用 next 即拦截该请求
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// IRouteGroup 为一段连续 msgId 的路由分组, 组内的消息共享中间件与默认处理逻辑
+type IRouteGroup interface {
+	Range() (start, end uint32)                                  // 分组包含的 msgId 范围 [start, end]
+	Use(middlewares ...Middleware)                               // 添加中间件, 先添加的在外层
+	AddRouter(msgId uint32, router IRouter, opts ...RouteOption) // 在分组内注册路由, msgId 须在分组范围内
+	SetDefault(router IRouter, opts ...RouteOption)              // 设置组内未注册 msgId 的默认处理逻辑, 为 nil 时取消
+}
@@ -0,0 +1,9 @@
+package ziface
+
+// ILogger 为 zinx 内部使用的结构化日志接口, args 为交替出现的 key, value; *slog.Logger 直接实现了该接口
+type ILogger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
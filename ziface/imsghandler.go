@@ -1,8 +1,50 @@
 package ziface
 
+import "time"
+
+// Priority 为消息的优先级, worker 总是先处理高优先级的消息
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1 // 低优先级, 如批量的聊天消息
+	PriorityNormal Priority = 0  // 默认优先级
+	PriorityHigh   Priority = 1  // 高优先级, 如登录, 心跳
+)
+
+// RouteInfo 描述一条路由的注册信息
+type RouteInfo struct {
+	MsgId       uint32        // 消息 ID
+	Router      IRouter       // 处理该消息的 Router
+	Anonymous   bool          // 未认证的连接也可以调用, 即认证白名单
+	RequireAuth bool          // 即使未设置 Authenticator, 也要求连接已附加身份
+	Roles       []string      // 要求身份具备其中任一角色, 为空表示不限角色
+	Codec       ICodec        // 类型化路由使用的编解码器, 为 nil 时使用 Server 的编解码器
+	ReplyMsgId  uint32        // 类型化路由回复使用的消息 ID, 默认与 MsgId 相同
+	Unordered   bool          // 处理逻辑不依赖连接内消息的顺序, 可以被空闲的 worker 窃取
+	Priority    Priority      // 消息的优先级, 决定消息进入 worker 的哪个优先级通道
+	Timeout     time.Duration // 处理链的超时时间, 为 0 表示不限制
+	IsDefault   bool          // 为分组的默认路由, 处理组内多个未注册的 msgId; 分发时 MsgId 为实际处理的 msgId, 未指定 ReplyMsgId 时回复同样使用该 msgId
+}
+
+// RouteOption 在 AddRouter 时为路由声明额外的属性
+type RouteOption func(*RouteInfo)
+
 type IMsgHandle interface {
-	DoMsgHandler(request IRequest)          // 立即以非阻塞的方式处理消息
-	AddRouter(msgId uint32, router IRouter) // 为消息添加具体的处理逻辑
-	StartWorkerPool()                       // 启动 worker 工作池
-	SendMsgToTaskQueue(request IRequest)    // 将消息交给 TaskQueue, 由 worker 进行处理
+	DoMsgHandler(request IRequest)                                                 // 立即以非阻塞的方式处理消息
+	AddRouter(msgId uint32, router IRouter, opts ...RouteOption)                   // 为消息添加具体的处理逻辑
+	ReplaceRouter(msgId uint32, router IRouter, opts ...RouteOption) IRouter       // 注册或替换消息的处理逻辑, 返回被替换的 Router
+	RemoveRouter(msgId uint32) error                                               // 删除消息的处理逻辑
+	Group(start, end uint32) IRouteGroup                                           // 创建包含 msgId 范围 [start, end] 的路由分组
+	Routes() []RouteInfo                                                           // 获取全部路由注册信息的快照
+	StartWorkerPool()                                                              // 启动 worker 工作池
+	SetWorkerPoolSize(size uint32)                                                 // 调整工作池的 worker 数量, 队列中的消息会被迁移
+	GetWorkerPoolSize() uint32                                                     // 获取当前工作池的 worker 数量
+	SendMsgToTaskQueue(request IRequest)                                           // 将消息交给工作池, 由 worker 进行处理
+	SetOnQueueFull(hook func(conn IConnection, msgId uint32))                      // 设置任务队列已满时的 hook 函数
+	SetOnTimeout(hook func(conn IConnection, msgId uint32, timeout time.Duration)) // 设置路由处理超时的 hook 函数
+	SetOnTick(hook func(tick uint64, requests []IRequest))                         // 设置 tick 模式下每个 tick 调用的回调
+	SetAuthenticator(loginMsgId uint32, auth Authenticator)                        // 设置认证方法, 此后未认证的连接只能调用白名单中的消息
+	AuthEnabled() bool                                                             // 是否设置了认证方法
+	SetLogger(logger ILogger)                                                      // 设置日志
+	SetTracer(tracer ITracer)                                                      // 设置追踪钩子
 }
@@ -1,8 +1,13 @@
 package ziface
 
+import "context"
+
 // IRequest 为接口, Request 打包客户端请求的连接信息以及请求数据
 type IRequest interface {
 	GetConnection() IConnection // 获取请求连接信息
 	GetData() []byte            // 获取请求消息的数据
 	GetMsgID() uint32           // 获取请求的 id
+
+	Context() context.Context       // 获取请求的 context, 默认即为连接的 context, 取消它会影响整个连接
+	SetContext(ctx context.Context) // 替换请求的 context, 供 PreHandle 等中间环节附加 deadline, 用户 id 等信息; 需要请求级别的取消时设置派生的 context
 }
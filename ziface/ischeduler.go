@@ -0,0 +1,23 @@
+package ziface
+
+import "time"
+
+// ITask 为调度器中的一个定时任务
+type ITask interface {
+	Cancel() bool // 取消任务, 返回任务是否因此不再执行; 一次性任务已经执行或已被取消时返回 false
+}
+
+// TaskInfo 为定时任务的选项
+type TaskInfo struct {
+	Conn     IConnection // 任务所属的连接, 连接 Stop 时任务被取消; 为 nil 时任务属于 Server
+	OnWorker bool        // 在 Conn 对应的 worker 中执行, 与该连接的消息保持顺序; 未设置 Conn 时无效
+}
+
+// TaskOption 为注册定时任务时的可选项
+type TaskOption func(*TaskInfo)
+
+// IScheduler 为基于时间轮的定时任务调度器, 精度为时间轮的 tick
+type IScheduler interface {
+	After(delay time.Duration, fn func(), opts ...TaskOption) ITask    // delay 之后执行一次 fn
+	Every(interval time.Duration, fn func(), opts ...TaskOption) ITask // 每隔 interval 执行一次 fn, 直到任务被取消
+}
@@ -1,15 +1,50 @@
 package ziface
 
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
 // 定义服务器接口
 type IServer interface {
-	Start()                                 // Start 启动服务器方法
-	Stop()                                  // Stop 停止服务器方法
-	Serve()                                 // Serve 开启服务器方法
-	AddRouter(msgId uint32, router IRouter) // 路由功能: 给当前服务注册一个路由业务方法
-	GetConnMgr() IConnManager               // 得到连接管理器
+	Start()                                                                  // Start 启动服务器方法
+	Stop()                                                                   // Stop 停止服务器方法
+	Serve()                                                                  // Serve 开启服务器方法
+	Addr() net.Addr                                                          // 获取服务器实际监听的地址, 尚未开始监听时为 nil
+	AddRouter(msgId uint32, router IRouter, opts ...RouteOption)             // 路由功能: 给当前服务注册一个路由业务方法
+	ReplaceRouter(msgId uint32, router IRouter, opts ...RouteOption) IRouter // 运行时注册或替换路由, 返回被替换的 Router
+	RemoveRouter(msgId uint32) error                                         // 运行时删除路由
+	Group(start, end uint32) IRouteGroup                                     // 创建包含 msgId 范围 [start, end] 的路由分组
+	Routes() []RouteInfo                                                     // 获取全部路由注册信息的快照
+	GetConnMgr() IConnManager                                                // 得到连接管理器
+	GetScheduler() IScheduler                                                // 得到定时任务调度器
+	SetWorkerPoolSize(size uint32)                                           // 运行时调整工作池的 worker 数量
+	GetWorkerPoolSize() uint32                                               // 获取当前工作池的 worker 数量
 
 	SetOnConnStart(func(IConnection)) // 设置该 Server 在连接创建时的 hook 函数
 	SetOnConnStop(func(IConnection))  // 设置该 Server 在连接断开时的 hook 函数
 	CallOnConnStart(conn IConnection) // 调用连接 onConnStart Hook 函数
 	CallOnConnStop(conn IConnection)  // 调用连接 onConnStop Hook 函数
+
+	SetOnQueueFull(func(conn IConnection, msgId uint32))                      // 设置任务队列已满时的 hook 函数, 如用于告警
+	SetOnTimeout(func(conn IConnection, msgId uint32, timeout time.Duration)) // 设置路由处理超时的 hook 函数
+	SetOnTick(func(tick uint64, requests []IRequest))                         // 设置 tick 模式下每个 tick 调用的回调, 参数为该 tick 收集的全部消息
+
+	SetAuthenticator(loginMsgId uint32, auth Authenticator) // 设置认证方法, 连接须先通过 loginMsgId 登录才能调用其它消息
+
+	BanIP(ip string, duration time.Duration) error // 封禁 ip 一段时间 (<= 0 表示永久), 并断开该 IP 的全部连接
+	UnbanIP(ip string) error                       // 解除对 ip 的封禁
+
+	SetLogger(logger ILogger) // 设置 Server 及其连接, 消息处理模块使用的日志
+	GetLogger() ILogger       // 获取 Server 使用的日志
+	SetTracer(tracer ITracer) // 设置追踪钩子, 每个请求的处理过程都会创建 span
+
+	SetCodec(codec ICodec)                // 设置类型化路由默认使用的编解码器
+	GetCodec() ICodec                     // 获取类型化路由默认使用的编解码器
+	SetErrorHandler(handler ErrorHandler) // 设置类型化路由的错误处理方法
+	GetErrorHandler() ErrorHandler        // 获取类型化路由的错误处理方法
+
+	MetricsHandler() http.Handler // 以 Prometheus 文本格式导出指标的 http.Handler
+	AdminHandler() http.Handler   // 管理后台的 http.Handler, 须携带 admin.token 访问
 }
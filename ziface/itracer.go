@@ -0,0 +1,29 @@
+package ziface
+
+import "context"
+
+// SpanContext 为跨进程传递的追踪上下文, 与 OpenTelemetry 的 TraceID, SpanID 布局一致
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid 判断追踪上下文是否有效, TraceID 与 SpanID 均不能全为 0
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// ISpan 为一次操作的追踪区间, 方法与 OpenTelemetry 的 trace.Span 对应
+type ISpan interface {
+	SetAttribute(key string, value any) // 设置属性
+	RecordError(err error)              // 记录错误
+	End()                               // 结束 span
+	SpanContext() SpanContext           // 获取 span 的追踪上下文
+}
+
+// ITracer 为追踪钩子, 可以适配到 OpenTelemetry 等追踪系统.
+// Start 以 ctx 中的 span 为父 span 创建新的 span, 并返回携带新 span 的 ctx;
+// ctx 中没有本地 span 时, 应使用 znet.SpanContextFromContext 取得的远程父 span
+type ITracer interface {
+	Start(ctx context.Context, name string) (context.Context, ISpan)
+}
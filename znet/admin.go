@@ -0,0 +1,275 @@
+package znet
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// adminConn 为管理后台展示的连接信息
+type adminConn struct {
+	ConnID     uint32            `json:"conn_id"`
+	RemoteAddr string            `json:"remote_addr"`
+	ProxyAddr  string            `json:"proxy_addr,omitempty"`
+	Uptime     string            `json:"uptime"`
+	Identity   string            `json:"identity,omitempty"`
+	Properties map[string]string `json:"properties"`
+	QueueLen   int               `json:"queue_len"`
+	QueueCap   int               `json:"queue_cap"`
+	BytesIn    uint64            `json:"bytes_in"`
+	BytesOut   uint64            `json:"bytes_out"`
+	Dropped    uint64            `json:"dropped"`
+}
+
+// adminRoute 为管理后台展示的路由信息
+type adminRoute struct {
+	MsgId       uint32   `json:"msg_id"`
+	Router      string   `json:"router"`
+	Anonymous   bool     `json:"anonymous"`
+	RequireAuth bool     `json:"require_auth"`
+	Roles       []string `json:"roles,omitempty"`
+	Priority    string   `json:"priority"`
+	Unordered   bool     `json:"unordered,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+}
+
+// adminWorkers 为管理后台展示的工作池状态
+type adminWorkers struct {
+	PoolSize     uint32        `json:"pool_size"`
+	DispatchMode string        `json:"dispatch_mode"`
+	QueueCap     int           `json:"queue_cap"`
+	RunQueueLen  int           `json:"run_queue_len,omitempty"`
+	Workers      []adminWorker `json:"workers"`
+}
+
+// adminWorker 为管理后台展示的单个 worker 状态
+type adminWorker struct {
+	WorkerID    int            `json:"worker_id"`
+	QueueLen    int            `json:"queue_len"`
+	LaneLens    map[string]int `json:"lane_lens"`
+	Handled     uint64         `json:"handled"`
+	Stolen      uint64         `json:"stolen"`
+	Starved     uint64         `json:"starved"`
+	Utilization float64        `json:"utilization"`
+}
+
+// AdminHandler 返回管理后台的 http.Handler, 所有接口都须携带 admin.token:
+// 请求头 Authorization: Bearer <token>. 不接受查询参数中的 token, 以免其出现在代理与访问日志及浏览器历史中
+//
+//	GET  /admin/conns             列出全部连接
+//	POST /admin/conns/{id}/kick   断开指定连接
+//	GET  /admin/routes            列出路由注册信息
+//	GET  /admin/workers           查看工作池状态
+//	PUT  /admin/workers           调整工作池的 worker 数量, 请求体为 {"size": 8}
+//	POST /admin/broadcast         向全部连接广播消息, 请求体为 {"msg_id": 1, "data": "..."}
+//	GET  /debug/pprof/            pprof, 如 /debug/pprof/goroutine?debug=2 导出全部 goroutine
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/conns", s.adminConns)
+	mux.HandleFunc("POST /admin/conns/{id}/kick", s.adminKick)
+	mux.HandleFunc("GET /admin/routes", s.adminRoutes)
+	mux.HandleFunc("GET /admin/workers", s.adminWorkers)
+	mux.HandleFunc("PUT /admin/workers", s.adminResizeWorkers)
+	mux.HandleFunc("POST /admin/broadcast", s.adminBroadcast)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthorized 校验请求携带的 token, 未配置 token 时拒绝一切请求
+func adminAuthorized(r *http.Request) bool {
+	token := settings.Conf.Admin.Token
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// serveAdmin 在 admin.addr 上启动管理后台, 未配置地址时不启动, 未配置 token 时拒绝启动
+func (s *Server) serveAdmin() {
+	addr := settings.Conf.Admin.Addr
+	if addr == "" {
+		return
+	}
+	if settings.Conf.Admin.Token == "" {
+		s.logger.Error("admin server disabled: admin.token is empty", "addr", addr)
+		return
+	}
+
+	s.adminServer = &http.Server{Addr: addr, Handler: s.AdminHandler()}
+	go func() {
+		s.logger.Info("admin server listening", "addr", addr)
+		if err := s.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("admin server failed", "addr", addr, "err", err)
+		}
+	}()
+}
+
+func (s *Server) adminConns(w http.ResponseWriter, r *http.Request) {
+	conns := s.ConnMgr.GetAll()
+	list := make([]adminConn, 0, len(conns))
+	for _, conn := range conns {
+		info := adminConn{
+			ConnID:     conn.GetConnID(),
+			RemoteAddr: addrString(conn.RemoteAddr()),
+			ProxyAddr:  addrString(conn.ProxyAddr()),
+			Dropped:    conn.GetDroppedMsgCount(),
+		}
+		if identity := conn.GetIdentity(); identity != nil {
+			info.Identity = identity.GetID()
+		}
+		if c, ok := conn.(*Connection); ok {
+			info.Uptime = time.Since(c.startTime).Round(time.Second).String()
+			info.QueueLen, info.QueueCap = len(c.msgBuffChan), cap(c.msgBuffChan)
+			info.BytesIn, info.BytesOut = c.bytesIn.Load(), c.bytesOut.Load()
+			info.Properties = c.properties()
+		}
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b adminConn) int { return int(a.ConnID) - int(b.ConnID) })
+	writeJSON(w, list)
+}
+
+func (s *Server) adminKick(w http.ResponseWriter, r *http.Request) {
+	connID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid conn id", http.StatusBadRequest)
+		return
+	}
+	conn, err := s.ConnMgr.Get(uint32(connID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.logger.Info("kick conn by admin", "connID", conn.GetConnID(), "remote", addrString(conn.RemoteAddr()))
+	conn.Stop()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminRoutes(w http.ResponseWriter, r *http.Request) {
+	routes := s.msgHandler.Routes()
+	list := make([]adminRoute, 0, len(routes))
+	for i := range routes {
+		list = append(list, newAdminRoute(&routes[i]))
+	}
+	writeJSON(w, list)
+}
+
+// newAdminRoute 将路由注册信息转换为管理后台展示的格式
+func newAdminRoute(route *ziface.RouteInfo) adminRoute {
+	info := adminRoute{
+		MsgId:       route.MsgId,
+		Router:      reflect.TypeOf(route.Router).String(),
+		Anonymous:   route.Anonymous,
+		RequireAuth: route.RequireAuth,
+		Roles:       route.Roles,
+		Priority:    laneNames[laneOf(route.Priority)],
+		Unordered:   route.Unordered,
+	}
+	if route.Timeout > 0 {
+		info.Timeout = route.Timeout.String()
+	}
+	return info
+}
+
+func (s *Server) adminWorkers(w http.ResponseWriter, r *http.Request) {
+	mh, ok := s.msgHandler.(*MsgHandle)
+	if !ok {
+		http.Error(w, "unsupported msg handler", http.StatusNotImplemented)
+		return
+	}
+
+	workers := *mh.workerList.Load()
+	status := adminWorkers{
+		PoolSize:     uint32(len(workers)),
+		DispatchMode: mh.dispatchMode,
+		QueueCap:     int(settings.Conf.MaxWorkerTaskLen),
+		RunQueueLen:  mh.runQueueLen(),
+		Workers:      make([]adminWorker, 0, len(workers)),
+	}
+	for _, wk := range workers {
+		info := adminWorker{
+			WorkerID:    wk.id,
+			QueueLen:    wk.queue.len() + wk.local.len(),
+			LaneLens:    make(map[string]int, laneCount),
+			Handled:     wk.stats.handled.Load(),
+			Stolen:      wk.stats.stolen.Load(),
+			Starved:     wk.stats.starved.Load(),
+			Utilization: wk.stats.utilization(),
+		}
+		for lane, name := range laneNames {
+			info.LaneLens[name] = len(wk.queue[lane]) + len(wk.local[lane])
+		}
+		status.Workers = append(status.Workers, info)
+	}
+	writeJSON(w, status)
+}
+
+func (s *Server) adminResizeWorkers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Size *uint32 `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Size == nil {
+		http.Error(w, "invalid body, want {\"size\": n}", http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("resize worker pool by admin", "from", s.msgHandler.GetWorkerPoolSize(), "to", *req.Size)
+	s.msgHandler.SetWorkerPoolSize(*req.Size)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminBroadcast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MsgId uint32 `json:"msg_id"`
+		Data  string `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 广播使用非阻塞发送, 发送队列已满的连接会被跳过, 不会阻塞管理后台
+	var sent, failed int
+	for _, conn := range s.ConnMgr.GetAll() {
+		if err := conn.TrySendBuffMsg(req.MsgId, []byte(req.Data)); err != nil {
+			failed++
+		} else {
+			sent++
+		}
+	}
+	s.logger.Info("broadcast by admin", "msgID", req.MsgId, "sent", sent, "failed", failed)
+	writeJSON(w, map[string]int{"sent": sent, "failed": failed})
+}
+
+// writeJSON 以 JSON 格式写出 v
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,43 @@
+package znet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zinx/settings"
+)
+
+func TestAdminHandler(t *testing.T) {
+	settings.Conf.Admin.Token = "secret"
+	defer func() { settings.Conf.Admin.Token = "" }()
+
+	s := NewServer().(*Server)
+	s.AddRouter(7, &countRouter{}, WithRoles("admin"))
+	h := s.AdminHandler()
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/admin/routes", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: code = %d", rec.Code)
+	}
+	// 查询参数中的 token 不被接受
+	if rec := do("GET", "/admin/routes?token=secret", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("token in query: code = %d", rec.Code)
+	}
+	rec := do("GET", "/admin/routes", "secret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"router":"*znet.countRouter"`) {
+		t.Fatalf("routes: code = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := do("POST", "/admin/conns/42/kick", "secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("kick unknown conn: code = %d", rec.Code)
+	}
+}
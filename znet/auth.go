@@ -0,0 +1,98 @@
+package znet
+
+import (
+	"runtime/debug"
+	"slices"
+	"zinx/ziface"
+)
+
+// Identity 为 ziface.IIdentity 的默认实现
+type Identity struct {
+	ID    string   // 身份的唯一标识
+	Roles []string // 身份具备的角色
+}
+
+var _ ziface.IIdentity = (*Identity)(nil)
+
+// NewIdentity 创建一个身份
+func NewIdentity(id string, roles ...string) *Identity {
+	return &Identity{ID: id, Roles: roles}
+}
+
+// GetID 获取身份的唯一标识
+func (i *Identity) GetID() string {
+	return i.ID
+}
+
+// GetRoles 获取身份具备的角色
+func (i *Identity) GetRoles() []string {
+	return i.Roles
+}
+
+// WithAnonymous 将路由加入认证白名单, 未认证的连接也可以调用, 如 ping
+func WithAnonymous() ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.Anonymous = true
+	}
+}
+
+// WithAuth 要求调用该路由的连接已经附加了身份
+func WithAuth() ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.RequireAuth = true
+	}
+}
+
+// WithRoles 要求调用该路由的连接的身份具备 roles 中的任一角色
+func WithRoles(roles ...string) ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.RequireAuth = true
+		info.Roles = append(info.Roles, roles...)
+	}
+}
+
+// authorized 判断请求所在的连接是否有权调用该路由
+func (mh *MsgHandle) authorized(request ziface.IRequest, route *ziface.RouteInfo) bool {
+	// 设置了认证方法时, 除白名单和登录消息外都需要认证. 登录消息按请求的 msgId 判断,
+	// 分组的默认路由等情况下 route.MsgId 与请求的 msgId 并不相同
+	needAuth := route.RequireAuth ||
+		(mh.authenticator != nil && !route.Anonymous && request.GetMsgID() != mh.loginMsgId)
+	if !needAuth {
+		return true
+	}
+
+	identity := request.GetConnection().GetIdentity()
+	if identity == nil {
+		return false
+	}
+	if len(route.Roles) == 0 {
+		return true
+	}
+	for _, role := range identity.GetRoles() {
+		if slices.Contains(route.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate 对登录消息调用认证方法, 成功时为连接附加身份. 认证方法中的 panic 与 Router 中的一样
+// 只影响当前消息, 按认证失败处理
+func (mh *MsgHandle) authenticate(request ziface.IRequest) {
+	defer func() {
+		if r := recover(); r != nil {
+			mh.metrics.panicked()
+			mh.logger.Error("authenticator panic", "connID", request.GetConnection().GetConnID(),
+				"msgID", request.GetMsgID(), "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	identity, err := mh.authenticator(request)
+	if err != nil || identity == nil {
+		mh.logger.Warn("authenticate failed", "connID", request.GetConnection().GetConnID(),
+			"remote", addrString(request.GetConnection().RemoteAddr()), "err", err)
+		return
+	}
+	request.GetConnection().SetIdentity(identity)
+	mh.logger.Info("authenticated", "connID", request.GetConnection().GetConnID(),
+		"remote", addrString(request.GetConnection().RemoteAddr()), "identity", identity.GetID())
+}
@@ -0,0 +1,132 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"zinx/ziface"
+)
+
+type countRouter struct {
+	BaseRouter
+	calls int
+}
+
+func (r *countRouter) Handle(request ziface.IRequest) {
+	r.calls++
+}
+
+func TestAuthGate(t *testing.T) {
+	const (
+		msgLogin uint32 = iota
+		msgPing
+		msgChat
+		msgKick
+	)
+	login, ping, chat, kick := &countRouter{}, &countRouter{}, &countRouter{}, &countRouter{}
+
+	mh := NewMsgHandle()
+	mh.SetAuthenticator(msgLogin, func(request ziface.IRequest) (ziface.IIdentity, error) {
+		if string(request.GetData()) != "secret" {
+			return nil, errors.New("bad password")
+		}
+		return NewIdentity("player", "member"), nil
+	})
+	mh.AddRouter(msgLogin, login)
+	mh.AddRouter(msgPing, ping, WithAnonymous())
+	mh.AddRouter(msgChat, chat)
+	mh.AddRouter(msgKick, kick, WithRoles("admin"))
+
+	conn := &Connection{ctx: context.Background()}
+	send := func(msgId uint32, data string) {
+		mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(msgId, []byte(data))))
+	}
+
+	// 未认证时只有白名单与登录消息可以调用
+	send(msgPing, "")
+	send(msgChat, "")
+	send(msgLogin, "wrong")
+	if ping.calls != 1 || chat.calls != 0 || login.calls != 1 || conn.GetIdentity() != nil {
+		t.Fatalf("before login: ping = %d, chat = %d, login = %d", ping.calls, chat.calls, login.calls)
+	}
+
+	send(msgLogin, "secret")
+	send(msgChat, "")
+	send(msgKick, "")
+	if conn.GetIdentity() == nil || chat.calls != 1 || kick.calls != 0 {
+		t.Fatalf("after login: chat = %d, kick = %d", chat.calls, kick.calls)
+	}
+
+	conn.SetIdentity(NewIdentity("gm", "admin"))
+	send(msgKick, "")
+	if kick.calls != 1 {
+		t.Fatalf("admin kick = %d", kick.calls)
+	}
+
+	t.Run("route msgId", testAuthGateRouteMsgId)
+}
+
+// testAuthGateRouteMsgId 检查 RouteInfo.MsgId 与请求的 msgId 不同的路由
+func testAuthGateRouteMsgId(t *testing.T) {
+	const (
+		msgLogin uint32 = 1000
+		msgAlias uint32 = 2000
+		msgOther uint32 = 1500
+	)
+	login, alias, fallback := &countRouter{}, &countRouter{}, &countRouter{}
+
+	mh := NewMsgHandle()
+	mh.SetAuthenticator(msgLogin, func(request ziface.IRequest) (ziface.IIdentity, error) {
+		return nil, errors.New("bad password")
+	})
+	mh.AddRouter(msgLogin, login)
+	// 回复使用登录消息 ID 的路由, 以及起始于登录消息 ID 的分组的默认路由, 其 RouteInfo 与请求的 msgId 不同,
+	// 都不能因此被当作登录消息而免于认证
+	mh.AddRouter(msgAlias, alias, WithReplyMsgId(msgLogin))
+	mh.Group(msgLogin, 1999).SetDefault(fallback)
+
+	conn := &Connection{ctx: context.Background()}
+	for _, msgId := range []uint32{msgAlias, msgOther} {
+		mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(msgId, nil)))
+	}
+	if alias.calls != 0 || fallback.calls != 0 {
+		t.Fatalf("unauthenticated: alias = %d, group default = %d, want 0", alias.calls, fallback.calls)
+	}
+
+	// 登录消息本身仍然无需认证
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(msgLogin, nil)))
+	if login.calls != 1 {
+		t.Fatalf("login = %d, want 1", login.calls)
+	}
+
+	conn.SetIdentity(NewIdentity("player"))
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(msgOther, nil)))
+	if fallback.calls != 1 {
+		t.Fatalf("authenticated group default = %d, want 1", fallback.calls)
+	}
+}
+
+func TestAuthenticatorPanic(t *testing.T) {
+	mh := NewMsgHandle()
+	mh.metrics = NewMetrics()
+	mh.SetAuthenticator(1, func(request ziface.IRequest) (ziface.IIdentity, error) {
+		panic("auth backend down")
+	})
+	login := &countRouter{}
+	// 登录消息声明为低优先级且无序, 仍按最高优先级在连接内按顺序处理
+	mh.AddRouter(1, login, WithPriority(ziface.PriorityLow), WithUnordered())
+
+	if lane, unordered := mh.routeClass(1); lane != laneHigh || unordered {
+		t.Errorf("login route class = %d, %v, want %d, false", lane, unordered, laneHigh)
+	}
+
+	// 认证方法中的 panic 按认证失败处理, 登录消息的 Router 仍然执行
+	conn := &Connection{ctx: context.Background()}
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1, nil)))
+	if login.calls != 1 || conn.GetIdentity() != nil {
+		t.Fatalf("login = %d, identity = %v", login.calls, conn.GetIdentity())
+	}
+	if n := mh.metrics.panics.Load(); n != 1 {
+		t.Errorf("panics = %d, want 1", n)
+	}
+}
@@ -0,0 +1,88 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// 任务队列已满时的背压策略
+const (
+	BackpressurePause      = "pause"      // 暂停读取该连接, 等到队列空出位置后再继续, 依靠 TCP 流控使客户端减速 (默认)
+	BackpressureDrop       = "drop"       // 丢弃该消息
+	BackpressureBusy       = "busy"       // 丢弃该消息, 并回复 busy_msg_id, 数据为被丢弃消息的 msgId
+	BackpressureDisconnect = "disconnect" // 断开连接
+)
+
+// ErrQueueFull 为任务队列已满, 消息被背压策略丢弃时记录在请求 span 上的错误
+var ErrQueueFull = errors.New("task queue full")
+
+// SetOnQueueFull 设置任务队列已满时的 hook 函数, 在连接的读 goroutine 中调用, 应尽快返回, 如用于告警
+func (mh *MsgHandle) SetOnQueueFull(hook func(conn ziface.IConnection, msgId uint32)) {
+	mh.onQueueFull = hook
+}
+
+// waitWorker 为 DispatchHash 模式下 pause 策略的等待: 等到消息所属 worker 的队列空出位置后放入消息,
+// 返回消息是否进入了队列. 等待期间不持有 poolLock, 否则一个慢处理加上一次待执行的工作池调整就会阻塞
+// 全部连接的分发; 为此等待方登记在 worker 的 waiters 中, worker 退役时等待方重新选择 worker
+func (mh *MsgHandle) waitWorker(request ziface.IRequest, lane int, unordered bool) bool {
+	done := request.GetConnection().Context().Done()
+	for {
+		mh.poolLock.RLock()
+		w := mh.workerOf(request)
+		if w == nil {
+			mh.poolLock.RUnlock()
+			go mh.DoMsgHandler(request)
+			return true
+		}
+		ch := w.lane(lane, unordered)
+		w.waiters.Add(1)
+		mh.poolLock.RUnlock()
+
+		select {
+		case ch <- request:
+			w.waiters.Done()
+			return true
+		case <-done:
+			w.waiters.Done()
+			return false
+		case <-w.stop:
+			// worker 已经退役, 按调整后的工作池重新选择
+			w.waiters.Done()
+		}
+	}
+}
+
+// queueFull 按 backpressure.policy 处理因任务队列已满而无法放入的消息. pause 策略下调用 wait 阻塞等待,
+// 返回消息最终是否进入了队列; 未进入队列的消息会被归还
+func (mh *MsgHandle) queueFull(request ziface.IRequest, wait func() bool) bool {
+	conn, msgId := request.GetConnection(), request.GetMsgID()
+	mh.metrics.taskQueueFull()
+	if mh.onQueueFull != nil {
+		mh.onQueueFull(conn, msgId)
+	}
+
+	switch settings.Conf.Backpressure.Policy {
+	case BackpressureDrop:
+		mh.logger.Debug("task queue full, drop msg", "connID", conn.GetConnID(), "msgID", msgId)
+	case BackpressureBusy:
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, msgId)
+		_ = conn.TrySendBuffMsg(settings.Conf.Backpressure.BusyMsgId, data)
+	case BackpressureDisconnect:
+		mh.logger.Warn("task queue full, disconnect", "connID", conn.GetConnID(),
+			"remote", addrString(conn.RemoteAddr()), "msgID", msgId)
+		conn.Stop()
+	default:
+		mh.logger.Debug("task queue full, pause reading", "connID", conn.GetConnID(), "msgID", msgId)
+		if wait() {
+			return true
+		}
+	}
+
+	mh.metrics.taskDropped()
+	mh.traceDrop(request, ErrQueueFull)
+	releaseRequest(request)
+	return false
+}
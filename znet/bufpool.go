@@ -0,0 +1,44 @@
+package znet
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// 字节缓冲池按 2 的幂分级, 最小 64B, 最大 1MB; 超出范围的缓冲直接分配, 不放回池中
+const (
+	minBufferShift = 6
+	maxBufferShift = 20
+)
+
+// bufferPools 中存放 *[]byte, 避免 []byte 放入 interface 时的额外分配
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// getBuffer 从缓冲池中取出一个长度为 n 的字节切片, 用完后须调用 putBuffer 归还
+func getBuffer(n int) *[]byte {
+	class := 0
+	if n > 1<<minBufferShift {
+		class = bits.Len(uint(n-1)) - minBufferShift
+	}
+	if class >= len(bufferPools) {
+		buf := make([]byte, n)
+		return &buf
+	}
+
+	if v := bufferPools[class].Get(); v != nil {
+		bp := v.(*[]byte)
+		*bp = (*bp)[:n]
+		return bp
+	}
+	buf := make([]byte, n, 1<<(class+minBufferShift))
+	return &buf
+}
+
+// putBuffer 将 getBuffer 取出的字节切片归还到缓冲池, 归还后调用方不得再使用该切片
+func putBuffer(bp *[]byte) {
+	size := cap(*bp)
+	if size < 1<<minBufferShift || size > 1<<maxBufferShift || size&(size-1) != 0 {
+		return
+	}
+	bufferPools[bits.Len(uint(size))-1-minBufferShift].Put(bp)
+}
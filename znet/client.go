@@ -0,0 +1,222 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// negotiateTimeout 为客户端等待协商回复的最长时间, 超时后视为服务端不支持任何特性
+const negotiateTimeout = 3 * time.Second
+
+type Client struct {
+	Addr              string         // 服务端地址, 如 127.0.0.1:7777
+	Conn              net.Conn       // 与服务端的连接
+	Compression       bool           // 是否向服务端请求启用压缩
+	CompressThreshold uint32         // 消息数据超过该长度才压缩
+	Secure            bool           // 是否在连接建立后进行安全通道握手
+	SecurePSK         string         // 安全通道的预共享密钥, 须与服务端一致
+	TraceContext      bool           // 是否向服务端请求在消息中携带追踪上下文, 见 SendMsgContext
+	Codec             ziface.ICodec  // SendTyped 使用的编解码器, 默认取自 settings.Conf.Codec
+	Logger            ziface.ILogger // 客户端使用的日志, 默认为 slog.Default()
+
+	dp        *DataPack
+	headData  []byte
+	features  byte       // 协商得到的特性
+	requested byte       // 向服务端请求的特性
+	pending   []*Message // 等待协商回复期间收到的其它消息
+	writeLock sync.Mutex // 保证并发发送时消息不会交错
+
+	reader io.Reader      // 读取消息帧的数据源, 启用安全通道时为解密后的明文流
+	secure *secureChannel // 安全通道, 未启用时为 nil
+}
+
+var _ ziface.IClient = (*Client)(nil)
+
+// NewClient 创建一个客户端, 压缩相关的配置默认取自 settings.Conf
+func NewClient(addr string) *Client {
+	dp := NewDataPack()
+	return &Client{
+		Addr:              addr,
+		Compression:       settings.Conf.Compression,
+		CompressThreshold: settings.Conf.CompressThreshold,
+		Secure:            settings.Conf.Secure,
+		SecurePSK:         settings.Conf.SecurePSK,
+		Logger:            slog.Default(),
+		Codec:             CodecByName(settings.Conf.Codec),
+		dp:                dp,
+		headData:          make([]byte, dp.GetHeadLen()),
+	}
+}
+
+// Start 连接服务端, 按配置完成安全通道握手; 若开启了任何特性则发送协商请求并等待回复
+func (c *Client) Start() error {
+	conn, err := net.Dial("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	c.Conn = conn
+	c.reader = conn
+
+	if c.Secure {
+		sc, err := secureHandshake(conn, false, c.SecurePSK)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		c.secure = sc
+		c.reader = newSecureReader(conn, sc)
+	}
+
+	if c.Compression {
+		c.requested |= FeatureCompression
+	}
+	if c.TraceContext {
+		c.requested |= FeatureTraceContext
+	}
+	if c.requested == 0 {
+		return nil
+	}
+	return c.negotiate()
+}
+
+// negotiate 发送协商请求并等待服务端回复, 期间收到的其它消息暂存起来留给 ReadMsg
+func (c *Client) negotiate() error {
+	if err := c.SendMsg(MsgIdNegotiate, []byte{c.requested}); err != nil {
+		return err
+	}
+
+	c.Conn.SetReadDeadline(time.Now().Add(negotiateTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := c.readMsg()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// 服务端不认识协商请求, 按未启用任何特性处理
+			c.Logger.Warn("negotiate timeout, no feature enabled", "addr", c.Addr)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Id == MsgIdNegotiate {
+			if len(msg.Data) > 0 {
+				c.features = msg.Data[0] & c.requested
+			}
+			return nil
+		}
+		c.pending = append(c.pending, msg)
+	}
+}
+
+// Stop 断开与服务端的连接
+func (c *Client) Stop() {
+	if c.Conn != nil {
+		c.Conn.Close()
+	}
+}
+
+// GetConn 获取底层的网络连接
+func (c *Client) GetConn() net.Conn {
+	return c.Conn
+}
+
+// SendMsg 向服务端发送消息, 已协商压缩且超过阈值的消息压缩后发送
+func (c *Client) SendMsg(msgId uint32, data []byte) error {
+	return c.sendFrame(msgId, 0, data)
+}
+
+// SendMsgContext 向服务端发送消息, 已协商 FeatureTraceContext 且 ctx 携带追踪上下文
+// (见 ContextWithSpanContext) 时, 追踪上下文随消息一并发送, 服务端的 span 以其为父 span
+func (c *Client) SendMsgContext(ctx context.Context, msgId uint32, data []byte) error {
+	sc := SpanContextFromContext(ctx)
+	if c.features&FeatureTraceContext == 0 || !sc.IsValid() {
+		return c.sendFrame(msgId, 0, data)
+	}
+
+	bp := getBuffer(traceContextLen + len(data))
+	defer putBuffer(bp)
+	putTraceContext(*bp, sc)
+	copy((*bp)[traceContextLen:], data)
+	return c.sendFrame(msgId, FlagTraceContext, *bp)
+}
+
+// SendTyped 以 Codec 编码 v 后发送给服务端
+func (c *Client) SendTyped(msgId uint32, v any) error {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrEncode, c.Codec.Name(), err)
+	}
+	return c.SendMsg(msgId, data)
+}
+
+// sendFrame 以 flags 为标志位封包并发送消息
+func (c *Client) sendFrame(msgId uint32, flags uint32, data []byte) error {
+	var frame *[]byte
+	if c.features&FeatureCompression != 0 && len(data) > int(c.CompressThreshold) {
+		frame = deflateFrame(msgId, flags, data)
+	}
+	if frame == nil {
+		frame = c.dp.packFrame(msgId, flags, uint32(len(data)), data)
+	}
+	defer putBuffer(frame)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	out := frame
+	if c.secure != nil {
+		// 启用安全通道时, 帧加密为一条记录后再写出
+		out = c.secure.seal(*frame)
+		defer putBuffer(out)
+	}
+	_, err := c.Conn.Write(*out)
+	return err
+}
+
+// ReadMsg 阻塞地读取服务端发来的一条消息, 压缩过的消息会被解压
+func (c *Client) ReadMsg() (ziface.IMessage, error) {
+	if len(c.pending) > 0 {
+		msg := c.pending[0]
+		c.pending = c.pending[1:]
+		return msg, nil
+	}
+	return c.readMsg()
+}
+
+func (c *Client) readMsg() (*Message, error) {
+	if _, err := io.ReadFull(c.reader, c.headData); err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := c.dp.unpackHead(c.headData, msg); err != nil {
+		return nil, err
+	}
+	msg.Data = make([]byte, msg.DataLen)
+	if _, err := io.ReadFull(c.reader, msg.Data); err != nil {
+		return nil, err
+	}
+
+	if msg.Flags&FlagCompressed != 0 {
+		// 发出协商请求后, 服务端可能先于协商回复启用压缩
+		if c.requested&FeatureCompression == 0 {
+			return nil, errors.New("unexpected compressed msg")
+		}
+		out, err := inflate(msg.Data, maxPacketSize())
+		if err != nil {
+			return nil, err
+		}
+		msg.Data = append([]byte(nil), *out...)
+		putBuffer(out)
+		msg.DataLen = uint32(len(msg.Data))
+		msg.Flags = 0
+	}
+	return msg, nil
+}
@@ -0,0 +1,243 @@
+package znet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+var (
+	ErrDecode = errors.New("decode msg error") // 类型化路由解码请求失败, 交给 ErrorHandler 的错误包装了该错误
+	ErrEncode = errors.New("encode msg error") // 类型化路由编码回复失败
+)
+
+// JSONCodec 为基于 encoding/json 的编解码器
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string                       { return "json" }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// ProtobufCodec 为 protobuf 编解码器的适配, 要求消息类型实现 Marshal, Unmarshal 方法,
+// gogoproto, vtprotobuf 等生成的代码均满足. 使用 google.golang.org/protobuf 时,
+// 可以通过 NewCodec("protobuf", ...) 包装 proto.Marshal 与 proto.Unmarshal
+type ProtobufCodec struct{}
+
+type protoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+func (ProtobufCodec) Name() string { return "protobuf" }
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement Marshal", v)
+	}
+	return m.Marshal()
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement Unmarshal", v)
+	}
+	return m.Unmarshal(data)
+}
+
+// MsgpackCodec 为 msgpack 编解码器的适配, 要求消息类型实现 tinylib/msgp 生成的 MarshalMsg, UnmarshalMsg 方法
+type MsgpackCodec struct{}
+
+type msgpackMessage interface {
+	MarshalMsg(b []byte) ([]byte, error)
+	UnmarshalMsg(b []byte) ([]byte, error)
+}
+
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(msgpackMessage)
+	if !ok {
+		return nil, fmt.Errorf("msgpack codec: %T does not implement MarshalMsg", v)
+	}
+	return m.MarshalMsg(nil)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(msgpackMessage)
+	if !ok {
+		return fmt.Errorf("msgpack codec: %T does not implement UnmarshalMsg", v)
+	}
+	_, err := m.UnmarshalMsg(data)
+	return err
+}
+
+// funcCodec 为由函数构造的编解码器
+type funcCodec struct {
+	name      string
+	marshal   func(v any) ([]byte, error)
+	unmarshal func(data []byte, v any) error
+}
+
+func (c *funcCodec) Name() string                       { return c.name }
+func (c *funcCodec) Marshal(v any) ([]byte, error)      { return c.marshal(v) }
+func (c *funcCodec) Unmarshal(data []byte, v any) error { return c.unmarshal(data, v) }
+
+// NewCodec 由编解码函数构造编解码器, 用于适配第三方的序列化库
+func NewCodec(name string, marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) ziface.ICodec {
+	return &funcCodec{name: name, marshal: marshal, unmarshal: unmarshal}
+}
+
+// CodecByName 按名称取得内置的编解码器, 未知的名称返回 JSONCodec
+func CodecByName(name string) ziface.ICodec {
+	switch name {
+	case "protobuf":
+		return ProtobufCodec{}
+	case "msgpack":
+		return MsgpackCodec{}
+	default:
+		return JSONCodec{}
+	}
+}
+
+// WithCodec 为类型化路由指定编解码器, 覆盖 Server 的编解码器
+func WithCodec(codec ziface.ICodec) ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.Codec = codec
+	}
+}
+
+// WithReplyMsgId 指定类型化路由回复使用的消息 ID
+func WithReplyMsgId(msgId uint32) ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.ReplyMsgId = msgId
+	}
+}
+
+// typedRouter 为 AddTypedRouter 注册的 Router, 负责解码请求, 调用业务方法并编码回复
+type typedRouter[Req, Resp any] struct {
+	BaseRouter
+	server     ziface.IServer
+	codec      ziface.ICodec
+	replyMsgId uint32
+	fn         func(ctx context.Context, req *Req) (*Resp, error)
+}
+
+// AddTypedRouter 注册类型化路由: 以路由或 Server 的编解码器将请求数据解码为 Req,
+// 调用 fn, 再将返回的 Resp 编码后以 ReplyMsgId 回复. fn 返回 nil 的 Resp 时不回复;
+// 解码失败或 fn 返回错误时交给 Server 的 ErrorHandler
+func AddTypedRouter[Req, Resp any](s ziface.IServer, msgId uint32,
+	fn func(ctx context.Context, req *Req) (*Resp, error), opts ...ziface.RouteOption) {
+	info := &ziface.RouteInfo{MsgId: msgId, ReplyMsgId: msgId}
+	for _, opt := range opts {
+		opt(info)
+	}
+
+	s.AddRouter(msgId, &typedRouter[Req, Resp]{
+		server:     s,
+		codec:      info.Codec,
+		replyMsgId: info.ReplyMsgId,
+		fn:         fn,
+	}, opts...)
+}
+
+func (r *typedRouter[Req, Resp]) Handle(request ziface.IRequest) {
+	codec := r.codec
+	if codec == nil {
+		codec = r.server.GetCodec()
+	}
+
+	req := new(Req)
+	if err := codec.Unmarshal(request.GetData(), req); err != nil {
+		r.server.GetErrorHandler()(request, fmt.Errorf("%w: %s: %w", ErrDecode, codec.Name(), err))
+		return
+	}
+
+	resp, err := r.fn(request.Context(), req)
+	if err != nil {
+		r.server.GetErrorHandler()(request, err)
+		return
+	}
+	if resp == nil {
+		return
+	}
+
+	data, err := codec.Marshal(resp)
+	if err != nil {
+		r.server.GetErrorHandler()(request, fmt.Errorf("%w: %s: %w", ErrEncode, codec.Name(), err))
+		return
+	}
+	if err := request.GetConnection().SendBuffMsg(r.replyMsgId, data); err != nil {
+		r.server.GetLogger().Warn("send typed reply failed", "connID", request.GetConnection().GetConnID(),
+			"msgID", r.replyMsgId, "err", err)
+	}
+}
+
+// DecodeRequest 以连接所属 Server 的编解码器将请求数据解码到 v 中,
+// 解码失败时先交给 Server 的 ErrorHandler, 再返回错误
+func DecodeRequest(request ziface.IRequest, v any) error {
+	var codec ziface.ICodec = JSONCodec{}
+	c, ok := request.GetConnection().(*Connection)
+	if ok && c.TCPServer != nil {
+		codec = c.TCPServer.GetCodec()
+	}
+
+	if err := codec.Unmarshal(request.GetData(), v); err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrDecode, codec.Name(), err)
+		if ok && c.TCPServer != nil {
+			c.TCPServer.GetErrorHandler()(request, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// SendTyped 以连接所属 Server 的编解码器编码 v, 并通过 SendBuffMsg 发送
+func SendTyped(conn ziface.IConnection, msgId uint32, v any) error {
+	var codec ziface.ICodec = JSONCodec{}
+	if c, ok := conn.(*Connection); ok && c.TCPServer != nil {
+		codec = c.TCPServer.GetCodec()
+	}
+
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrEncode, codec.Name(), err)
+	}
+	return conn.SendBuffMsg(msgId, data)
+}
+
+// defaultErrorHandler 记录错误日志, 配置了 error_msg_id 时将错误信息回复给客户端
+func (s *Server) defaultErrorHandler(request ziface.IRequest, err error) {
+	s.logger.Warn("typed router error", "connID", request.GetConnection().GetConnID(),
+		"remote", addrString(request.GetConnection().RemoteAddr()), "msgID", request.GetMsgID(), "err", err)
+	if msgId := settings.Conf.ErrorMsgId; msgId != 0 {
+		_ = request.GetConnection().SendBuffMsg(msgId, []byte(err.Error()))
+	}
+}
+
+// SetCodec 设置类型化路由默认使用的编解码器
+func (s *Server) SetCodec(codec ziface.ICodec) {
+	s.codec = codec
+}
+
+// GetCodec 获取类型化路由默认使用的编解码器
+func (s *Server) GetCodec() ziface.ICodec {
+	return s.codec
+}
+
+// SetErrorHandler 设置类型化路由的错误处理方法, 为 nil 时恢复默认的处理方法
+func (s *Server) SetErrorHandler(handler ziface.ErrorHandler) {
+	if handler == nil {
+		handler = s.defaultErrorHandler
+	}
+	s.errorHandler = handler
+}
+
+// GetErrorHandler 获取类型化路由的错误处理方法
+func (s *Server) GetErrorHandler() ziface.ErrorHandler {
+	return s.errorHandler
+}
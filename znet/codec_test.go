@@ -0,0 +1,38 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"zinx/ziface"
+)
+
+type addReq struct{ A, B int }
+type addResp struct{ Sum int }
+
+func TestTypedRouter(t *testing.T) {
+	s := NewServer().(*Server)
+	var handled error
+	s.SetErrorHandler(func(request ziface.IRequest, err error) { handled = err })
+	AddTypedRouter(s, 5, func(ctx context.Context, req *addReq) (*addResp, error) {
+		return &addResp{Sum: req.A + req.B}, nil
+	}, WithReplyMsgId(6))
+
+	conn := &Connection{ctx: context.Background(), TCPServer: s, server: s, msgBuffChan: make(chan *[]byte, 1)}
+	mh := s.msgHandler.(*MsgHandle)
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(5, []byte(`{"A":1,"B":2}`))))
+
+	frame := <-conn.msgBuffChan
+	msg := &Message{}
+	if err := NewDataPack().unpackHead((*frame)[:8], msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Id != 6 || string((*frame)[8:]) != `{"Sum":3}` {
+		t.Fatalf("reply msgId = %d, data = %s", msg.Id, (*frame)[8:])
+	}
+
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(5, []byte(`not json`))))
+	if !errors.Is(handled, ErrDecode) {
+		t.Fatalf("decode error = %v", handled)
+	}
+}
@@ -0,0 +1,117 @@
+package znet
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// flate 的 Writer 初始化开销很大, 因此压缩与解压对象都放入池中复用
+var (
+	flateWriterPool = sync.Pool{
+		New: func() interface{} {
+			fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return fw
+		},
+	}
+	flateReaderPool sync.Pool
+)
+
+// bufferWriter 将数据追加到池化的字节切片中, 容量不足时换用更大的池化切片
+type bufferWriter struct {
+	bp *[]byte
+}
+
+// grow 保证至少还能追加 n 个字节
+func (w *bufferWriter) grow(n int) {
+	buf := *w.bp
+	if len(buf)+n <= cap(buf) {
+		return
+	}
+	nbp := getBuffer(max(2*cap(buf), len(buf)+n))
+	*nbp = (*nbp)[:copy(*nbp, buf)]
+	putBuffer(w.bp)
+	w.bp = nbp
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	w.grow(len(p))
+	*w.bp = append(*w.bp, p...)
+	return len(p), nil
+}
+
+// ReadFrom 直接读入切片的剩余空间, 避免 io.Copy 分配中间缓冲
+func (w *bufferWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		w.grow(512)
+		buf := *w.bp
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		*w.bp = buf[:len(buf)+n]
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// deflateFrame 压缩 data 并封包为带 FlagCompressed 以及 flags 标志的消息.
+// 压缩后没有变小时返回 nil, 调用方应按未压缩的方式封包.
+func deflateFrame(msgId uint32, flags uint32, data []byte) *[]byte {
+	headLen := int(NewDataPack().GetHeadLen())
+	w := &bufferWriter{bp: getBuffer(headLen + len(data)/2)}
+	*w.bp = (*w.bp)[:headLen] // 预留包头
+
+	fw := flateWriterPool.Get().(*flate.Writer)
+	fw.Reset(w)
+	_, err := fw.Write(data)
+	if err == nil {
+		err = fw.Close()
+	}
+	flateWriterPool.Put(fw)
+
+	compressedLen := len(*w.bp) - headLen
+	if err != nil || compressedLen >= len(data) {
+		putBuffer(w.bp)
+		return nil
+	}
+
+	// 回填包头
+	binary.LittleEndian.PutUint32((*w.bp)[0:4], uint32(compressedLen)|FlagCompressed|flags&flagMask)
+	binary.LittleEndian.PutUint32((*w.bp)[4:8], msgId)
+	return w.bp
+}
+
+// inflate 解压 src, 解压后的长度超过 limit 时返回错误, 以防御压缩炸弹.
+// 返回的切片取自缓冲池, 用完后须通过 putBuffer 归还.
+func inflate(src []byte, limit uint32) (*[]byte, error) {
+	var fr io.ReadCloser
+	if v := flateReaderPool.Get(); v != nil {
+		fr = v.(io.ReadCloser)
+		if err := fr.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		fr = flate.NewReader(bytes.NewReader(src))
+	}
+	defer flateReaderPool.Put(fr)
+
+	w := &bufferWriter{bp: getBuffer(0)}
+	// 多读一个字节, 用于判断解压后的长度是否超过限制
+	n, err := w.ReadFrom(io.LimitReader(fr, int64(limit)+1))
+	if err != nil {
+		putBuffer(w.bp)
+		return nil, err
+	}
+	if n > int64(limit) {
+		putBuffer(w.bp)
+		return nil, errors.New("Too large msg data after decompress")
+	}
+	return w.bp, nil
+}
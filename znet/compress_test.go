@@ -0,0 +1,57 @@
+package znet
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestCompressedFrame(t *testing.T) {
+	data := bytes.Repeat([]byte("inventory "), 1000)
+	frame := deflateFrame(3, 0, data)
+	if frame == nil {
+		t.Fatal("compressible data not compressed")
+	}
+	defer putBuffer(frame)
+
+	dp := NewDataPack()
+	c := &Connection{ctx: context.Background()}
+	c.compression.Store(true)
+
+	req, err := c.readRequest(bytes.NewReader(*frame), dp, make([]byte, dp.GetHeadLen()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetMsgID() != 3 || !bytes.Equal(req.GetData(), data) {
+		t.Fatalf("decompressed msgId = %d, len = %d", req.GetMsgID(), len(req.GetData()))
+	}
+	req.release()
+
+	// 未协商压缩的连接拒绝压缩消息
+	c.compression.Store(false)
+	if _, err := c.readRequest(bytes.NewReader(*frame), dp, make([]byte, dp.GetHeadLen())); err == nil {
+		t.Fatal("compressed msg accepted without negotiation")
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	// 压缩炸弹: 1MB 的 0 压缩后只有约 1KB
+	frame := deflateFrame(1, 0, make([]byte, 1<<20))
+	if frame == nil {
+		t.Fatal("compressible data not compressed")
+	}
+	defer putBuffer(frame)
+
+	body := (*frame)[NewDataPack().GetHeadLen():]
+	if _, err := inflate(body, 4096); err == nil {
+		t.Fatal("inflate exceeded limit without error")
+	}
+	out, err := inflate(body, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*out) != 1<<20 {
+		t.Fatalf("inflate len = %d", len(*out))
+	}
+	putBuffer(out)
+}
@@ -1,44 +1,94 @@
 package znet
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 	"zinx/settings"
 	"zinx/ziface"
 )
 
 type Connection struct {
-	TCPServer    ziface.IServer    // 标记当前 Conn 属于哪个 Server
-	Conn         *net.TCPConn      // 当前连接的 socket TCP 套接字
-	ConnID       uint32            // 当前连接的 ID, 也可称为 SessionID, 全局唯一
-	isClosed     bool              // 当前连接的开启/关闭状态
-	Msghandler   ziface.IMsgHandle // 将 Router 替换为消息管理模块
-	ExitBuffChan chan bool         // 告知该连接一经退出/停止的 channel
-	msgChan      chan []byte       // 无缓冲 channel, 用于读/写两个 goroutine 之间的消息通信
-	msgBuffChan  chan []byte       // 定义 msgBuffChan
+	TCPServer   ziface.IServer     // 标记当前 Conn 属于哪个 Server
+	server      *Server            // TCPServer 为本包的 Server 时指向它, 用于限速等内部能力, 否则为 nil
+	Conn        *net.TCPConn       // 当前连接的 socket TCP 套接字
+	ConnID      uint32             // 当前连接的 ID, 也可称为 SessionID, 全局唯一
+	remoteAddr  net.Addr           // 客户端的真实地址, 经过 PROXY protocol 时取自其头部
+	proxyAddr   net.Addr           // 代理的地址, 客户端直连时为 nil
+	isClosed    bool               // 当前连接的开启/关闭状态
+	closeLock   sync.Mutex         // 保护 isClosed 的锁
+	Msghandler  ziface.IMsgHandle  // 将 Router 替换为消息管理模块
+	ctx         context.Context    // 连接的 context, Stop 时被取消, 读写 goroutine 以此感知连接退出
+	cancel      context.CancelFunc // 取消连接 context 的方法
+	msgChan     chan *[]byte       // 无缓冲 channel, 用于读/写两个 goroutine 之间的消息通信, 消息缓冲取自缓冲池
+	msgBuffChan chan *[]byte       // 定义 msgBuffChan
+
+	overflowPolicy atomic.Int32  // 缓冲队列已满时的处理策略, 取值为 ziface.OverflowPolicy
+	droppedMsgs    atomic.Uint64 // 因缓冲队列已满而被丢弃的消息数量
+	compression    atomic.Bool   // 是否已与客户端协商启用压缩
+	traceContext   atomic.Bool   // 是否已与客户端协商在消息帧中携带追踪上下文
+	started        bool          // OnConnStart Hook 是否已经结束, 决定 Stop 时是否调用 OnConnStop, 由 closeLock 保护
+	startTime      time.Time     // 连接建立的时间
+	bytesIn        atomic.Uint64 // 收到的消息帧字节数
+	bytesOut       atomic.Uint64 // 发出的消息帧字节数
+
+	reader  io.Reader      // 读取消息帧的数据源, 启用安全通道时为解密后的明文流
+	secure  *secureChannel // 安全通道, 未启用时为 nil
+	limiter *connLimiter   // 入站消息限速状态, 未配置限速时为 nil
+
+	mailbox     *mailbox  // DispatchOrdered 模式下的串行消息队列, 首次分发消息时创建
+	mailboxOnce sync.Once // 保证 mailbox 只创建一次
 
 	property     map[string]interface{} // 连接属性
 	propertyLock sync.RWMutex           // 保护连接属性修改的锁
+	identity     ziface.IIdentity       // 认证后附加的身份, 同样由 propertyLock 保护
 }
 
+var (
+	ErrConnClosed    = errors.New("connection closed") // 连接已经关闭
+	ErrSendQueueFull = errors.New("send queue full")   // 缓冲发送队列已满
+	ErrSendTimeout   = errors.New("send msg timeout")  // 发送超时
+)
+
 // 确保 Connection 实现 ziface.IConenction 方法
 var _ ziface.IConnection = (*Connection)(nil)
 
 // NewConnection 创建新的连接
 func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandle) *Connection {
+	return newConnection(server, conn, connID, msgHandler, conn.RemoteAddr(), nil)
+}
+
+// newConnection 创建新的连接, remoteAddr 为客户端的真实地址, proxyAddr 为代理的地址 (直连时为 nil)
+func newConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandle,
+	remoteAddr net.Addr, proxyAddr net.Addr) *Connection {
 	c := &Connection{
-		TCPServer:    server,
-		Conn:         conn,
-		ConnID:       connID,
-		isClosed:     false,
-		Msghandler:   msgHandler,
-		ExitBuffChan: make(chan bool, 1),
-		msgChan:      make(chan []byte), // msgChan 初始化
-		msgBuffChan:  make(chan []byte, settings.Conf.MaxMsgChanLen),
-		property:     make(map[string]interface{}),
+		TCPServer:   server,
+		Conn:        conn,
+		ConnID:      connID,
+		remoteAddr:  remoteAddr,
+		proxyAddr:   proxyAddr,
+		isClosed:    false,
+		Msghandler:  msgHandler,
+		msgChan:     make(chan *[]byte), // msgChan 初始化
+		msgBuffChan: make(chan *[]byte, settings.Conf.MaxMsgChanLen),
+		property:    make(map[string]interface{}),
+		reader:      conn,
+		startTime:   time.Now(),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.overflowPolicy.Store(int32(ParseOverflowPolicy(settings.Conf.SendOverflowPolicy)))
+	if s, ok := server.(*Server); ok {
+		c.server = s
+		if s.limiter != nil {
+			c.limiter = s.limiter.acquire(c.RemoteAddr())
+		}
 	}
 
 	// 将新创建的 Conn 添加到连接管理器中
@@ -47,6 +97,22 @@ func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgH
 	return c
 }
 
+// metrics 返回连接所属 Server 的指标, 不属于本包的 Server 时为 nil
+func (c *Connection) metrics() *Metrics {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.metrics
+}
+
+// logger 返回连接所属 Server 的日志
+func (c *Connection) logger() ziface.ILogger {
+	if c.TCPServer == nil {
+		return slog.Default()
+	}
+	return c.TCPServer.GetLogger()
+}
+
 // SetProperty 用于设置连接属性
 func (c *Connection) SetProperty(key string, value interface{}) {
 	c.propertyLock.Lock()
@@ -67,6 +133,18 @@ func (c *Connection) GetProperty(key string) (interface{}, error) {
 	}
 }
 
+// properties 以字符串形式返回全部连接属性的快照
+func (c *Connection) properties() map[string]string {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+
+	props := make(map[string]string, len(c.property))
+	for key, value := range c.property {
+		props[key] = fmt.Sprint(value)
+	}
+	return props
+}
+
 // RemoveProperty 移除连接属性
 func (c *Connection) RemoveProperty(key string) {
 	c.propertyLock.Lock()
@@ -75,128 +153,311 @@ func (c *Connection) RemoveProperty(key string) {
 	delete(c.property, key)
 }
 
+// SetIdentity 为连接附加认证后的身份
+func (c *Connection) SetIdentity(identity ziface.IIdentity) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+
+	c.identity = identity
+}
+
+// GetIdentity 获取连接的身份, 未认证时为 nil
+func (c *Connection) GetIdentity() ziface.IIdentity {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+
+	return c.identity
+}
+
+// defaultMaxWriteBatch 为未配置 max_write_batch 时, 写 goroutine 单次合并写出的最大消息数
+const defaultMaxWriteBatch = 64
+
+// StartWriter 开启向客户端写数据的 goroutine.
+// 每取到一条消息, 就把两个发送队列中已经排队的消息一并取出 (最多 max_write_batch 条),
+// 通过 net.Buffers 以一次 writev 系统调用写出; 若配置了 write_flush_latency,
+// 批次未满时最多再等待该时长以凑满批次. 写出后的消息缓冲归还到缓冲池.
 func (c *Connection) StartWriter() {
-	fmt.Println("[Writer Goroutine is running]")
-	defer fmt.Println(c.RemoteAddr().String(), "[conn Writer exit!]")
+	c.logger().Debug("writer goroutine running", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()))
+	defer c.logger().Debug("writer goroutine exit", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()))
+
+	maxBatch := int(settings.Conf.MaxWriteBatch)
+	if maxBatch <= 0 {
+		maxBatch = defaultMaxWriteBatch
+	}
+	latency := settings.Conf.WriteFlushLatency
+	frames := make([]*[]byte, 0, maxBatch)
+	bufs := make(net.Buffers, 0, maxBatch)
 
 	for {
 		select {
-		case data := <-c.msgChan:
-			if _, err := c.Conn.Write(data); err != nil {
-				fmt.Println("Send Data error:", err, " Conn Writer exit~")
-				return
-			}
-		case data, ok := <-c.msgBuffChan:
-			if ok {
-				if _, err := c.Conn.Write(data); err != nil {
-					fmt.Println("Send Buff Data error:", err, " Conn Writer exit")
-					return
-				}
-			} else {
-				fmt.Println("msgBuffChan is Closed")
-				return
-			}
-		case <-c.ExitBuffChan:
+		case frame := <-c.msgChan:
+			frames = append(frames, frame)
+		case frame := <-c.msgBuffChan:
+			frames = append(frames, frame)
+		case <-c.ctx.Done():
 			// conn 关闭
 			return
 		}
+
+		frames = c.fillBatch(frames, maxBatch, latency)
+		for i, frame := range frames {
+			c.bytesOut.Add(uint64(len(*frame)))
+			c.metrics().msgOut(binary.LittleEndian.Uint32((*frame)[4:8]), len(*frame))
+			// 启用安全通道时, 每个帧加密为一条记录后再写出
+			if c.secure != nil {
+				frames[i] = c.secure.seal(*frame)
+				putBuffer(frame)
+			}
+			bufs = append(bufs, *frames[i])
+		}
+		err := c.flush(bufs)
+		for _, frame := range frames {
+			putBuffer(frame)
+		}
+		clear(frames)
+		frames = frames[:0]
+		clear(bufs)
+		bufs = bufs[:0]
+		if err != nil {
+			c.logger().Warn("send data failed", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()), "err", err)
+			return
+		}
 	}
 }
 
+// fillBatch 将发送队列中已经排队的消息追加到 frames 中, 直到批次已满或队列为空;
+// latency > 0 时, 队列为空后最多再等待 latency 以凑满批次
+func (c *Connection) fillBatch(frames []*[]byte, maxBatch int, latency time.Duration) []*[]byte {
+	var timeout <-chan time.Time
+	for len(frames) < maxBatch {
+		select {
+		case frame := <-c.msgChan:
+			frames = append(frames, frame)
+			continue
+		case frame := <-c.msgBuffChan:
+			frames = append(frames, frame)
+			continue
+		default:
+		}
+
+		if latency <= 0 {
+			return frames
+		}
+		if timeout == nil {
+			timer := time.NewTimer(latency)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case frame := <-c.msgChan:
+			frames = append(frames, frame)
+		case frame := <-c.msgBuffChan:
+			frames = append(frames, frame)
+		case <-timeout:
+			return frames
+		case <-c.ctx.Done():
+			return frames
+		}
+	}
+	return frames
+}
+
+// flush 将一批消息写入 socket, 多条消息时使用 writev 一次写出
+func (c *Connection) flush(bufs net.Buffers) error {
+	if len(bufs) == 1 {
+		_, err := c.Conn.Write(bufs[0])
+		return err
+	}
+	// WriteTo 会消费传入的 net.Buffers, 因此传入副本, 保留 bufs 的底层数组以便复用
+	pending := bufs
+	_, err := pending.WriteTo(c.Conn)
+	return err
+}
+
 // StartReader 开启处理 conn 读数据的 goroutine
 func (c *Connection) StartReader() {
-	fmt.Println("Reader Goroutine is running")
-	defer fmt.Println(c.RemoteAddr().String(), " conn reader exit !")
+	c.logger().Debug("reader goroutine running", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()))
+	defer c.logger().Debug("reader goroutine exit", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()))
 	defer c.Stop()
 
-	for {
-		// 创建封包拆包的对象
-		dp := NewDataPack()
-
-		// 读取客户端的 msg head
-		headData := make([]byte, dp.GetHeadLen()) // 注意 GetHeadLen() 返回常量 8, 因为包的头部长度固定
-		if _, err := io.ReadFull(c.GetTCPConnection(), headData); err != nil {
-			fmt.Println("read msg head error", err)
-			c.ExitBuffChan <- true
-			return
-		}
+	// 封包拆包的对象与包头缓冲在整个连接的生命周期内复用
+	dp := NewDataPack()
+	headData := make([]byte, dp.GetHeadLen()) // 注意 GetHeadLen() 返回常量 8, 因为包的头部长度固定
 
-		// 拆包, 得到 msgid 和 datalen, 并放在 msg 中
-		msg, err := dp.Unpack(headData)
+	for first := true; ; first = false {
+		// 得到当前客户端请求的 Request 数据
+		req, err := c.readRequest(c.reader, dp, headData)
 		if err != nil {
-			fmt.Println("unpack error", err)
-			c.ExitBuffChan <- true
+			c.logger().Debug("read msg failed", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()), "err", err)
 			return
 		}
 
-		// 根据 dataLen 读取 data, 放在 msg.Data 中
-		var data []byte
-		if msg.GetDataLen() > 0 {
-			data = make([]byte, msg.GetDataLen())
-			if _, err := io.ReadFull(c.GetTCPConnection(), data); err != nil {
-				fmt.Println("read msg data error", err)
-				c.ExitBuffChan <- true
+		// 只有连接上的第一条消息可以是协商请求
+		if first && req.GetMsgID() == MsgIdNegotiate {
+			err := c.negotiate(req.GetData())
+			req.release()
+			if err != nil {
+				c.logger().Warn("negotiate failed", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()), "err", err)
 				return
 			}
+			continue
 		}
-		msg.SetData(data)
 
-		// 得到当前客户端请求的 Request 数据
-		req := Request{
-			conn: c,
-			msg:  msg,
+		// 超出速率限制的消息按限速策略处理, 不再交给 MsgHandle
+		if c.limiter != nil && !c.admitRequest(req) {
+			req.release()
+			continue
 		}
 
-		if settings.Conf.WorkerPoolSize > 0 {
-			// 已经启动工作池机制, 将消息交给 Worker 处理
-			c.Msghandler.SendMsgToTaskQueue(&req)
-		} else {
-			// 从绑定好的消息和对应的处理方法中执行 Handle 方法
-			go c.Msghandler.DoMsgHandler(&req)
+		// 由 MsgHandle 按分发模式交给 worker 处理, 工作池为空时直接启动 goroutine 处理
+		c.Msghandler.SendMsgToTaskQueue(req)
+	}
+}
+
+// readRequest 从 r 中读取一个完整的消息, 请求与消息数据均取自缓冲池,
+// 在处理链执行完毕后由 MsgHandle 归还
+func (c *Connection) readRequest(r io.Reader, dp *DataPack, headData []byte) (*Request, error) {
+	// 读取客户端的 msg head
+	if _, err := io.ReadFull(r, headData); err != nil {
+		return nil, fmt.Errorf("read msg head error: %w", err)
+	}
+
+	// 拆包, 得到 msgid 和 datalen, 并放在 msg 中
+	req := getRequest(c)
+	if err := dp.unpackHead(headData, &req.message); err != nil {
+		req.release()
+		return nil, fmt.Errorf("unpack error: %w", err)
+	}
+
+	// 根据 dataLen 读取 data, 放在 msg.Data 中
+	if dataLen := req.message.GetDataLen(); dataLen > 0 {
+		req.buf = getBuffer(int(dataLen))
+		if _, err := io.ReadFull(r, *req.buf); err != nil {
+			req.release()
+			return nil, fmt.Errorf("read msg data error: %w", err)
+		}
+		req.message.SetData(*req.buf)
+	}
+	frameLen := len(headData) + int(req.message.GetDataLen())
+	c.bytesIn.Add(uint64(frameLen))
+	c.metrics().msgIn(req.message.Id, frameLen)
+
+	// 解压经过压缩的消息, 解压后的长度同样受 MaxPacketSize 限制
+	if req.message.Flags&FlagCompressed != 0 {
+		if !c.compression.Load() || req.buf == nil {
+			req.release()
+			return nil, errors.New("unpack error: unexpected compressed msg")
+		}
+		out, err := inflate(*req.buf, maxPacketSize())
+		if err != nil {
+			req.release()
+			return nil, fmt.Errorf("decompress msg data error: %w", err)
+		}
+		putBuffer(req.buf)
+		req.buf = out
+		req.message.SetData(*out)
+		req.message.SetDataLen(uint32(len(*out)))
+		req.message.SetFlags(req.message.Flags &^ FlagCompressed)
+	}
+
+	// 取出客户端随消息带来的追踪上下文, 之后的数据才是真正的消息数据
+	if req.message.Flags&FlagTraceContext != 0 {
+		data := req.message.GetData()
+		if !c.traceContext.Load() || len(data) < traceContextLen {
+			req.release()
+			return nil, errors.New("unpack error: unexpected trace context")
 		}
+		req.traceParent = readTraceContext(data)
+		req.message.SetData(data[traceContextLen:])
+		req.message.SetDataLen(uint32(len(data) - traceContextLen))
+		req.message.SetFlags(req.message.Flags &^ FlagTraceContext)
 	}
+	return req, nil
 }
 
 // Start 实现 IConnection 中的方法, 它启动连接并让当前连接开始工作
 func (c *Connection) Start() {
+	// 启用安全通道时, 在任何业务之前先完成握手
+	if settings.Conf.Secure {
+		sc, err := secureHandshake(c.Conn, true, settings.Conf.SecurePSK)
+		if err != nil {
+			c.logger().Warn("secure handshake failed", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()), "err", err)
+			c.Stop()
+			return
+		}
+		c.secure = sc
+		c.reader = newSecureReader(c.Conn, sc)
+	}
+
 	// 开启处理该连接读取到客户端数据之后的业务请求
 	go c.StartWriter()
 	go c.StartReader()
 
-	c.TCPServer.CallOnConnStart(c)
+	// 设置了认证方法时, 超过 auth_timeout 仍未登录的连接将被关闭
+	if timeout := settings.Conf.AuthTimeout; timeout > 0 && c.Msghandler.AuthEnabled() {
+		timer := time.AfterFunc(timeout, func() {
+			if c.GetIdentity() == nil {
+				c.logger().Info("auth timeout", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()))
+				c.Stop()
+			}
+		})
+		defer timer.Stop()
+	}
 
-	for {
-		select {
-		case <-c.ExitBuffChan:
-			// 得到退出消息则不再阻塞
-			return
+	// OnConnStart 开始前已经 Stop 的连接不再调用任何 Hook
+	c.closeLock.Lock()
+	closed := c.isClosed
+	c.closeLock.Unlock()
+	if !closed {
+		c.TCPServer.CallOnConnStart(c)
+		// OnConnStart 结束后才允许 Stop 调用 OnConnStop; 期间已经 Stop 的连接在此补充调用, 保证 Hook 成对且不并发
+		c.closeLock.Lock()
+		c.started = true
+		closed = c.isClosed
+		c.closeLock.Unlock()
+		if closed {
+			c.TCPServer.CallOnConnStop(c)
 		}
 	}
+
+	// 阻塞直到连接的 context 被取消, 即连接已经 Stop
+	<-c.ctx.Done()
 }
 
 // Stop 停止连接, 结束当前连接状态
 func (c *Connection) Stop() {
-	fmt.Println("Conn Stop()... ConnID = ", c.ConnID)
 	// 1. 如果当前连接已经关闭
+	c.closeLock.Lock()
 	if c.isClosed == true {
+		c.closeLock.Unlock()
 		return
 	}
 	c.isClosed = true
+	started := c.started
+	c.closeLock.Unlock()
+	c.logger().Debug("conn stop", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()))
 
 	// Connection Stop() 如果用户注册了该连接的关闭回调业务, 那么应该在此刻显式调用
-	c.TCPServer.CallOnConnStop(c)
+	// 握手失败等原因未曾调用 OnConnStart 的连接, 也不调用 OnConnStop; OnConnStart 尚未结束时由 Start 调用
+	if started {
+		c.TCPServer.CallOnConnStop(c)
+	}
 
+	// 取消连接的 context, 通知读写 goroutine 及所有派生自该 context 的请求: 该链接已经关闭
+	c.cancel()
 	// 关闭 socket 连接
 	c.Conn.Close()
-	// 通知从缓冲队列读数据的业务, 该链接已经关闭
-	c.ExitBuffChan <- true
 
 	// 将连接从管理器中删除
 	c.TCPServer.GetConnMgr().Remove(c)
 
-	// 关闭该链接全部管道
-	close(c.ExitBuffChan)
-	close(c.msgBuffChan)
+	// 清理限速状态
+	if c.limiter != nil {
+		c.limiter.release()
+	}
+
+	// 注意: msgBuffChan 不再 close, 发送方通过 ctx 感知连接关闭, 避免向已关闭的 channel 写入而 panic
 }
 
 // GetTCPConnection 从当前连接获取原始的 socket TCPConn
@@ -209,43 +470,179 @@ func (c *Connection) GetConnID() uint32 {
 	return c.ConnID
 }
 
-// RemoteAddr 获取远程客户端的地址信息
+// RemoteAddr 获取远程客户端的地址信息, 经过 PROXY protocol 时为其头部中的真实客户端地址
 func (c *Connection) RemoteAddr() net.Addr {
-	return c.Conn.RemoteAddr()
+	return c.remoteAddr
+}
+
+// ProxyAddr 获取代理的地址, 客户端直连时为 nil
+func (c *Connection) ProxyAddr() net.Addr {
+	return c.proxyAddr
+}
+
+// Context 获取连接的 context, 连接 Stop 时该 context 会被取消
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// packMsg 将 msgId 与 data 封包到缓冲池的字节切片中, 写 goroutine 写出后负责归还
+func (c *Connection) packMsg(msgId uint32, data []byte) (*[]byte, error) {
+	if c.ctx.Err() != nil {
+		return nil, ErrConnClosed
+	}
+
+	// 已协商压缩且超过阈值的消息压缩后发送
+	if c.compression.Load() && len(data) > int(settings.Conf.CompressThreshold) {
+		if frame := deflateFrame(msgId, 0, data); frame != nil {
+			return frame, nil
+		}
+	}
+
+	dp := DataPack{}
+	return dp.packFrame(msgId, 0, uint32(len(data)), data), nil
 }
 
+// SendMsg 直接将消息交给写 goroutine, 阻塞直到写 goroutine 取走消息或连接关闭
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
-	if c.isClosed == true {
-		return errors.New("Connection closed when send msg")
+	msg, err := c.packMsg(msgId, data)
+	if err != nil {
+		return err
 	}
 
-	// 将 data 封包
-	dp := NewDataPack()
-	msg, err := dp.Pack(NewMsgPackage(msgId, data))
+	// 将 data 发送, 若连接在此期间关闭则不再阻塞
+	select {
+	case c.msgChan <- msg:
+		return nil
+	case <-c.ctx.Done():
+		putBuffer(msg)
+		return ErrConnClosed
+	}
+}
+
+// SendMsgTimeout 与 SendMsg 相同, 但最多等待 timeout, 超时返回 ErrSendTimeout
+func (c *Connection) SendMsgTimeout(msgId uint32, data []byte, timeout time.Duration) error {
+	msg, err := c.packMsg(msgId, data)
 	if err != nil {
-		fmt.Println("Pack error msg id = ", msgId)
-		return errors.New("Pack error msg ")
+		return err
 	}
 
-	// 将 data 发送
-	c.msgChan <- msg
-	return nil
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case c.msgChan <- msg:
+		return nil
+	case <-timer.C:
+		putBuffer(msg)
+		return ErrSendTimeout
+	case <-c.ctx.Done():
+		putBuffer(msg)
+		return ErrConnClosed
+	}
 }
 
+// SendBuffMsg 将消息写入缓冲队列, 队列已满时按照连接的 OverflowPolicy 处理
 func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
-	if c.isClosed == true {
-		return errors.New("Connection closed when send buff msg")
+	msg, err := c.packMsg(msgId, data)
+	if err != nil {
+		return err
 	}
 
-	// 将 data 封包并发送
-	dp := NewDataPack()
-	msg, err := dp.Pack(NewMsgPackage(msgId, data))
+	switch ziface.OverflowPolicy(c.overflowPolicy.Load()) {
+	case ziface.OverflowDropNewest:
+		select {
+		case c.msgBuffChan <- msg:
+			return nil
+		default:
+		}
+		// 队列已满, 连接已经关闭时队列不会再被取走, 与 block 策略一样返回 ErrConnClosed
+		putBuffer(msg)
+		if c.ctx.Err() != nil {
+			return ErrConnClosed
+		}
+		c.droppedMsgs.Add(1)
+		return nil
+	case ziface.OverflowDropOldest:
+		for {
+			select {
+			case c.msgBuffChan <- msg:
+				return nil
+			default:
+			}
+			// 队列已满, 丢弃队首最旧的一条消息后重试
+			select {
+			case oldest := <-c.msgBuffChan:
+				putBuffer(oldest)
+				c.droppedMsgs.Add(1)
+			default:
+			}
+			if c.ctx.Err() != nil {
+				putBuffer(msg)
+				return ErrConnClosed
+			}
+		}
+	case ziface.OverflowDisconnect:
+		select {
+		case c.msgBuffChan <- msg:
+			return nil
+		default:
+			putBuffer(msg)
+			if c.ctx.Err() != nil {
+				return ErrConnClosed
+			}
+			c.droppedMsgs.Add(1)
+			c.logger().Warn("send queue full, disconnect slow consumer", "connID", c.ConnID,
+				"remote", addrString(c.RemoteAddr()), "msgID", msgId)
+			c.Stop()
+			return ErrSendQueueFull
+		}
+	default:
+		select {
+		case c.msgBuffChan <- msg:
+			return nil
+		case <-c.ctx.Done():
+			putBuffer(msg)
+			return ErrConnClosed
+		}
+	}
+}
+
+// TrySendBuffMsg 非阻塞地将消息写入缓冲队列, 队列已满时返回 ErrSendQueueFull, 不受 OverflowPolicy 影响
+func (c *Connection) TrySendBuffMsg(msgId uint32, data []byte) error {
+	msg, err := c.packMsg(msgId, data)
 	if err != nil {
-		fmt.Println("Pack error msg id = ", msgId)
-		return errors.New("Pack error msg")
+		return err
+	}
+
+	select {
+	case c.msgBuffChan <- msg:
+		return nil
+	default:
+		putBuffer(msg)
+		return ErrSendQueueFull
 	}
+}
 
-	c.msgBuffChan <- msg
+// SetOverflowPolicy 设置缓冲队列已满时 SendBuffMsg 的处理策略
+func (c *Connection) SetOverflowPolicy(policy ziface.OverflowPolicy) {
+	c.overflowPolicy.Store(int32(policy))
+}
+
+// GetDroppedMsgCount 获取因缓冲队列已满而被丢弃的消息数量
+func (c *Connection) GetDroppedMsgCount() uint64 {
+	return c.droppedMsgs.Load()
+}
 
-	return nil
+// ParseOverflowPolicy 将配置文件中的策略名称转换为 ziface.OverflowPolicy, 未知名称按 OverflowBlock 处理
+func ParseOverflowPolicy(name string) ziface.OverflowPolicy {
+	switch name {
+	case "drop_newest":
+		return ziface.OverflowDropNewest
+	case "drop_oldest":
+		return ziface.OverflowDropOldest
+	case "disconnect":
+		return ziface.OverflowDisconnect
+	default:
+		return ziface.OverflowBlock
+	}
 }
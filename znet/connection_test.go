@@ -0,0 +1,236 @@
+package znet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// tcpPair 建立一对本地 TCP 连接, 返回服务端一侧的连接与对端
+func tcpPair(tb testing.TB) (*net.TCPConn, net.Conn) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+
+	peer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conn, err := ln.AcceptTCP()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return conn, peer
+}
+
+// newBenchConnection 建立一对本地 TCP 连接, 返回服务端一侧的 Connection;
+// 对端收满 expected 字节 (或出错) 后, 通过返回的 channel 报告实际收到的字节数
+func newBenchConnection(b *testing.B, expected int64) (*Connection, <-chan int64) {
+	conn, peer := tcpPair(b)
+	received := make(chan int64, 1)
+	go func() {
+		n, _ := io.CopyN(io.Discard, peer, expected)
+		received <- n
+	}()
+
+	c := NewConnection(NewServer(), conn, 0, NewMsgHandle())
+	b.Cleanup(func() {
+		c.Stop()
+		peer.Close()
+	})
+	return c, received
+}
+
+func benchmarkWriter(b *testing.B, maxBatch uint32) {
+	chanLen, writeBatch, flushLatency := settings.Conf.MaxMsgChanLen, settings.Conf.MaxWriteBatch, settings.Conf.WriteFlushLatency
+	b.Cleanup(func() {
+		settings.Conf.MaxMsgChanLen, settings.Conf.MaxWriteBatch, settings.Conf.WriteFlushLatency = chanLen, writeBatch, flushLatency
+	})
+	settings.Conf.MaxMsgChanLen = 1024
+	settings.Conf.MaxWriteBatch = maxBatch
+	settings.Conf.WriteFlushLatency = 0
+
+	payload := make([]byte, 64)
+	frameLen := int64(NewDataPack().GetHeadLen()) + int64(len(payload))
+
+	c, received := newBenchConnection(b, frameLen*int64(b.N))
+	go c.StartWriter()
+
+	b.SetBytes(frameLen)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.SendBuffMsg(1, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// 等待对端收到全部消息
+	if n := <-received; n != frameLen*int64(b.N) {
+		b.Fatalf("peer received %d bytes, want %d", n, frameLen*int64(b.N))
+	}
+}
+
+// BenchmarkWriterPerMessage 每条消息一次 Write 系统调用, 即批量写出之前的行为
+func BenchmarkWriterPerMessage(b *testing.B) {
+	benchmarkWriter(b, 1)
+}
+
+// BenchmarkWriterBatched 合并已排队的消息, 以 writev 一次写出
+func BenchmarkWriterBatched(b *testing.B) {
+	benchmarkWriter(b, defaultMaxWriteBatch)
+}
+
+// newSendTestConnection 创建缓冲发送队列容量为 1 且没有启动写 goroutine 的 Connection, 发出的消息都留在队列中
+func newSendTestConnection(t *testing.T, policy ziface.OverflowPolicy) *Connection {
+	chanLen := settings.Conf.MaxMsgChanLen
+	settings.Conf.MaxMsgChanLen = 1
+	defer func() { settings.Conf.MaxMsgChanLen = chanLen }()
+
+	conn, peer := tcpPair(t)
+	c := NewConnection(NewServer(), conn, 0, NewMsgHandle())
+	c.SetOverflowPolicy(policy)
+	t.Cleanup(func() {
+		c.Stop()
+		peer.Close()
+	})
+	return c
+}
+
+// queuedMsgId 取出缓冲发送队列中的一条消息, 返回其 msgId
+func queuedMsgId(t *testing.T, c *Connection) uint32 {
+	select {
+	case frame := <-c.msgBuffChan:
+		head, err := NewDataPack().Unpack((*frame)[:NewDataPack().GetHeadLen()])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return head.GetMsgId()
+	default:
+		t.Fatal("send queue empty")
+		return 0
+	}
+}
+
+func TestSendBuffMsgOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ziface.OverflowPolicy
+		err    error  // 队列已满时发送第二条消息的返回值
+		queued uint32 // 之后队列中剩下的消息
+		closed bool   // 连接是否因此断开
+	}{
+		{"drop_newest", ziface.OverflowDropNewest, nil, 1, false},
+		{"drop_oldest", ziface.OverflowDropOldest, nil, 2, false},
+		{"disconnect", ziface.OverflowDisconnect, ErrSendQueueFull, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSendTestConnection(t, tt.policy)
+			if err := c.SendBuffMsg(1, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.SendBuffMsg(2, nil); !errors.Is(err, tt.err) {
+				t.Fatalf("SendBuffMsg on full queue = %v, want %v", err, tt.err)
+			}
+			if n := c.GetDroppedMsgCount(); n != 1 {
+				t.Errorf("dropped = %d, want 1", n)
+			}
+			if closed := c.Context().Err() != nil; closed != tt.closed {
+				t.Errorf("closed = %v, want %v", closed, tt.closed)
+			}
+			if msgId := queuedMsgId(t, c); msgId != tt.queued {
+				t.Errorf("queued msgId = %d, want %d", msgId, tt.queued)
+			}
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		c := newSendTestConnection(t, ziface.OverflowBlock)
+		if err := c.SendBuffMsg(1, nil); err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error, 1)
+		go func() { errs <- c.SendBuffMsg(2, nil) }()
+		select {
+		case err := <-errs:
+			t.Fatalf("SendBuffMsg on full queue returned %v, want blocked", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		// 连接关闭后阻塞的发送返回 ErrConnClosed
+		c.Stop()
+		if err := <-errs; !errors.Is(err, ErrConnClosed) {
+			t.Fatalf("blocked SendBuffMsg after Stop = %v, want ErrConnClosed", err)
+		}
+		if n := c.GetDroppedMsgCount(); n != 0 {
+			t.Errorf("dropped = %d, want 0", n)
+		}
+	})
+}
+
+func TestSendClosedConn(t *testing.T) {
+	policies := []struct {
+		name   string
+		policy ziface.OverflowPolicy
+	}{
+		{"block", ziface.OverflowBlock},
+		{"drop_newest", ziface.OverflowDropNewest},
+		{"drop_oldest", ziface.OverflowDropOldest},
+		{"disconnect", ziface.OverflowDisconnect},
+	}
+	for _, tt := range policies {
+		t.Run(tt.name, func(t *testing.T) {
+			// 队列已满且连接已关闭时, 不会再有写 goroutine 取走消息, 发送不能悄悄成功或计为丢弃
+			c := newSendTestConnection(t, tt.policy)
+			if err := c.SendBuffMsg(1, nil); err != nil {
+				t.Fatal(err)
+			}
+			c.Stop()
+			if err := c.SendBuffMsg(2, nil); !errors.Is(err, ErrConnClosed) {
+				t.Errorf("SendBuffMsg = %v, want ErrConnClosed", err)
+			}
+			if err := c.TrySendBuffMsg(2, nil); !errors.Is(err, ErrConnClosed) {
+				t.Errorf("TrySendBuffMsg = %v, want ErrConnClosed", err)
+			}
+			if err := c.SendMsgTimeout(2, nil, time.Second); !errors.Is(err, ErrConnClosed) {
+				t.Errorf("SendMsgTimeout = %v, want ErrConnClosed", err)
+			}
+			if n := c.GetDroppedMsgCount(); n != 0 {
+				t.Errorf("dropped = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestTrySendBuffMsg(t *testing.T) {
+	// TrySendBuffMsg 不受 OverflowPolicy 影响
+	c := newSendTestConnection(t, ziface.OverflowDropOldest)
+	if err := c.TrySendBuffMsg(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TrySendBuffMsg(2, nil); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("TrySendBuffMsg on full queue = %v, want ErrSendQueueFull", err)
+	}
+	if n := c.GetDroppedMsgCount(); n != 0 {
+		t.Errorf("dropped = %d, want 0", n)
+	}
+	if msgId := queuedMsgId(t, c); msgId != 1 {
+		t.Errorf("queued msgId = %d, want 1", msgId)
+	}
+}
+
+func TestSendMsgTimeout(t *testing.T) {
+	// 没有写 goroutine 取走消息, 等待 timeout 后返回 ErrSendTimeout
+	c := newSendTestConnection(t, ziface.OverflowBlock)
+	start := time.Now()
+	if err := c.SendMsgTimeout(1, nil, 20*time.Millisecond); !errors.Is(err, ErrSendTimeout) {
+		t.Fatalf("SendMsgTimeout = %v, want ErrSendTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("SendMsgTimeout returned after %v, want >= 20ms", elapsed)
+	}
+}
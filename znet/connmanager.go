@@ -2,22 +2,31 @@ package znet
 
 import (
 	"errors"
-	"fmt"
+	"log/slog"
 	"sync"
 	"zinx/ziface"
 )
 
 type ConnManager struct {
 	connections map[uint32]ziface.IConnection // 管理连接的信息
+	ipConns     map[string]int                // 每个远程 IP 的连接数量
 	connLock    sync.RWMutex                  // 读写连接的读写锁
+	logger      ziface.ILogger                // 日志
 }
 
 func NewConnManager() *ConnManager {
 	return &ConnManager{
 		connections: make(map[uint32]ziface.IConnection),
+		ipConns:     make(map[string]int),
+		logger:      slog.Default(),
 	}
 }
 
+// SetLogger 设置日志
+func (connMgr *ConnManager) SetLogger(logger ziface.ILogger) {
+	connMgr.logger = logger
+}
+
 // Add 添加连接
 func (connMgr *ConnManager) Add(conn ziface.IConnection) {
 	// 保护共享资源 Map, 加写锁
@@ -26,8 +35,10 @@ func (connMgr *ConnManager) Add(conn ziface.IConnection) {
 
 	// 将 conn 连接添加到 ConnManager
 	connMgr.connections[conn.GetConnID()] = conn
+	connMgr.ipConns[remoteIP(conn.RemoteAddr())]++
 
-	fmt.Println("connection add to ConnManager successfully: conn num = ", connMgr.Len())
+	connMgr.logger.Debug("connection added to ConnManager", "connID", conn.GetConnID(),
+		"remote", addrString(conn.RemoteAddr()), "conns", len(connMgr.connections))
 }
 
 // Remove 删除连接
@@ -37,9 +48,13 @@ func (connMgr *ConnManager) Remove(conn ziface.IConnection) {
 	defer connMgr.connLock.Unlock()
 
 	// 删除连接信息
-	delete(connMgr.connections, conn.GetConnID())
+	if _, ok := connMgr.connections[conn.GetConnID()]; ok {
+		delete(connMgr.connections, conn.GetConnID())
+		connMgr.releaseIP(conn)
+	}
 
-	fmt.Println("connection Remove connID = ", conn.GetConnID(), " successfully: conn num = ", connMgr.Len())
+	connMgr.logger.Debug("connection removed from ConnManager", "connID", conn.GetConnID(),
+		"remote", addrString(conn.RemoteAddr()), "conns", len(connMgr.connections))
 }
 
 // Get 利用 ConnID 获取连接
@@ -57,22 +72,57 @@ func (connMgr *ConnManager) Get(connID uint32) (ziface.IConnection, error) {
 
 // Len 获取当前连接个数
 func (connMgr *ConnManager) Len() int {
+	connMgr.connLock.RLock()
+	defer connMgr.connLock.RUnlock()
+
 	return len(connMgr.connections)
 }
 
+// LenByIP 获取来自某个远程 IP 的连接个数
+func (connMgr *ConnManager) LenByIP(ip string) int {
+	connMgr.connLock.RLock()
+	defer connMgr.connLock.RUnlock()
+
+	return connMgr.ipConns[ip]
+}
+
+// GetAll 获取当前全部连接的快照, 可以在遍历时安全地停止连接
+func (connMgr *ConnManager) GetAll() []ziface.IConnection {
+	connMgr.connLock.RLock()
+	defer connMgr.connLock.RUnlock()
+
+	conns := make([]ziface.IConnection, 0, len(connMgr.connections))
+	for _, conn := range connMgr.connections {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// releaseIP 减少连接所属 IP 的计数, 调用方须持有写锁
+func (connMgr *ConnManager) releaseIP(conn ziface.IConnection) {
+	ip := remoteIP(conn.RemoteAddr())
+	if connMgr.ipConns[ip]--; connMgr.ipConns[ip] <= 0 {
+		delete(connMgr.ipConns, ip)
+	}
+}
+
 // ClearConn 停止并清除当前所有连接
 func (connMgr *ConnManager) ClearConn() {
-	// 保护共享资源 Map, 加写锁
+	// 保护共享资源 Map, 加写锁, 先摘出全部连接
 	connMgr.connLock.Lock()
-	defer connMgr.connLock.Unlock()
-
-	// 停止并删除全部的连接信息
+	conns := make([]ziface.IConnection, 0, len(connMgr.connections))
 	for connID, conn := range connMgr.connections {
-		// 停止
-		conn.Stop()
+		conns = append(conns, conn)
 		// 删除
 		delete(connMgr.connections, connID)
+		connMgr.releaseIP(conn)
+	}
+	connMgr.connLock.Unlock()
+
+	// 停止全部的连接, conn.Stop() 内部会调用 Remove, 因此不能在持有锁时调用
+	for _, conn := range conns {
+		conn.Stop()
 	}
 
-	fmt.Println("Clear All Connections successfully: conn num = ", connMgr.Len())
+	connMgr.logger.Info("all connections cleared", "conns", len(conns))
 }
@@ -1,13 +1,22 @@
 package znet
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"zinx/settings"
 	"zinx/ziface"
 )
 
+// 包头 dataLen 字段的高 4 位用作消息标志位, 低 28 位为数据长度.
+// 标志位只在双方协商过对应特性后才会出现, 因此与旧版本的对端保持兼容.
+const (
+	FlagCompressed   uint32 = 1 << 31 // 消息数据经过 flate 压缩
+	FlagTraceContext uint32 = 1 << 30 // 消息数据 (解压后) 以 24 字节的追踪上下文开头
+
+	flagMask    uint32 = 0xF << 28
+	dataLenMask uint32 = ^flagMask
+)
+
 // DataPack 为用于封包和拆包的类, 暂时不需要成员
 type DataPack struct{}
 
@@ -26,49 +35,58 @@ func (dp *DataPack) GetHeadLen() uint32 {
 
 // Pack 为封包方法
 func (dp *DataPack) Pack(msg ziface.IMessage) ([]byte, error) {
-	// 创建一个存放 byte 字节的缓冲
-	dataBuff := bytes.NewBuffer([]byte{})
-
-	// 写 dataLen
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetDataLen()); err != nil {
-		return nil, err
-	}
+	return *dp.packFrame(msg.GetMsgId(), msg.GetFlags(), msg.GetDataLen(), msg.GetData()), nil
+}
 
-	// 写 msgID
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgId()); err != nil {
-		return nil, err
-	}
+// packFrame 将消息直接封包到缓冲池的字节切片中, 调用方在写出后应通过 putBuffer 归还
+func (dp *DataPack) packFrame(msgId uint32, flags uint32, dataLen uint32, data []byte) *[]byte {
+	headLen := dp.GetHeadLen()
+	bp := getBuffer(int(headLen) + len(data))
+	buf := *bp
 
+	// 写 dataLen (高 4 位为标志位), msgID
+	binary.LittleEndian.PutUint32(buf[0:4], dataLen&dataLenMask|flags&flagMask)
+	binary.LittleEndian.PutUint32(buf[4:8], msgId)
 	// 写 data 数据
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetData()); err != nil {
-		return nil, err
-	}
+	copy(buf[headLen:], data)
 
-	return dataBuff.Bytes(), nil
+	return bp
 }
 
 func (dp *DataPack) Unpack(binaryData []byte) (ziface.IMessage, error) {
-	// 创建一个用于输入二进制数据的 ioReader
-	dataBuff := bytes.NewReader(binaryData)
-
 	// 只解压 head 信息, 得到 dataLen 和 msgID
 	msg := &Message{}
-
-	// 读 dataLen
-	if err := binary.Read(dataBuff, binary.LittleEndian, &msg.DataLen); err != nil {
+	if err := dp.unpackHead(binaryData, msg); err != nil {
 		return nil, err
 	}
 
-	// 读 msgID
-	if err := binary.Read(dataBuff, binary.LittleEndian, &msg.Id); err != nil {
-		return nil, err
+	// 此处只需要把 head 的数据拆包出来即可, 再通过 head 的长度, 从 conn 读取一次数据
+	return msg, nil
+}
+
+// unpackHead 将包头解析到 msg 中, 不产生额外的内存分配
+func (dp *DataPack) unpackHead(binaryData []byte, msg *Message) error {
+	if len(binaryData) < int(dp.GetHeadLen()) {
+		return errors.New("msg head too short")
 	}
 
+	// 读 dataLen (高 4 位为标志位), msgID
+	lenAndFlags := binary.LittleEndian.Uint32(binaryData[0:4])
+	msg.Flags = lenAndFlags & flagMask
+	msg.DataLen = lenAndFlags & dataLenMask
+	msg.Id = binary.LittleEndian.Uint32(binaryData[4:8])
+
 	// 判断 dataLen 的长度是否超过了我们允许的最大包长度
 	if settings.Conf.MaxPacketSize > 0 && msg.DataLen > settings.Conf.MaxPacketSize {
-		return nil, errors.New("Too large msg data received")
+		return errors.New("Too large msg data received")
 	}
+	return nil
+}
 
-	// 此处只需要把 head 的数据拆包出来即可, 再通过 head 的长度, 从 conn 读取一次数据
-	return msg, nil
+// maxPacketSize 获取允许的最大包长度, 未配置时以包头能表示的最大长度为准
+func maxPacketSize() uint32 {
+	if settings.Conf.MaxPacketSize > 0 && settings.Conf.MaxPacketSize < dataLenMask {
+		return settings.Conf.MaxPacketSize
+	}
+	return dataLenMask
 }
@@ -0,0 +1,134 @@
+package znet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"zinx/ziface"
+)
+
+// packLegacy 为改用缓冲池之前的封包实现, 仅用于基准测试对比
+func packLegacy(msg ziface.IMessage) ([]byte, error) {
+	dataBuff := bytes.NewBuffer([]byte{})
+	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetDataLen()); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgId()); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetData()); err != nil {
+		return nil, err
+	}
+	return dataBuff.Bytes(), nil
+}
+
+// readFrameLegacy 为改用缓冲池之前 StartReader 读取一帧的流程, 仅用于基准测试对比
+func readFrameLegacy(c *Connection, r io.Reader) (ziface.IRequest, error) {
+	dp := NewDataPack()
+	headData := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(r, headData); err != nil {
+		return nil, err
+	}
+	msg, err := dp.Unpack(headData)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, msg.GetDataLen())
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	msg.SetData(data)
+	return NewRequest(c, msg), nil
+}
+
+func TestPackUnpack(t *testing.T) {
+	dp := NewDataPack()
+	frame, err := dp.Pack(NewMsgPackage(7, []byte("zinx")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := packLegacy(NewMsgPackage(7, []byte("zinx")))
+	if !bytes.Equal(frame, legacy) {
+		t.Fatalf("pack = %v, want %v", frame, legacy)
+	}
+
+	c := &Connection{ctx: context.Background()}
+	req, err := c.readRequest(bytes.NewReader(frame), dp, make([]byte, dp.GetHeadLen()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetMsgID() != 7 || string(req.GetData()) != "zinx" {
+		t.Fatalf("unpack got msgId = %d, data = %q", req.GetMsgID(), req.GetData())
+	}
+	req.release()
+}
+
+var benchPayload = make([]byte, 512)
+
+func BenchmarkPackLegacy(b *testing.B) {
+	b.ReportAllocs()
+	msg := NewMsgPackage(1, benchPayload)
+	for i := 0; i < b.N; i++ {
+		if _, err := packLegacy(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	b.ReportAllocs()
+	dp := NewDataPack()
+	for i := 0; i < b.N; i++ {
+		putBuffer(dp.packFrame(1, 0, uint32(len(benchPayload)), benchPayload))
+	}
+}
+
+func BenchmarkReadFrameLegacy(b *testing.B) {
+	b.ReportAllocs()
+	frame, _ := packLegacy(NewMsgPackage(1, benchPayload))
+	r := bytes.NewReader(frame)
+	c := &Connection{ctx: context.Background()}
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame)
+		if _, err := readFrameLegacy(c, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	b.ReportAllocs()
+	frame, _ := packLegacy(NewMsgPackage(1, benchPayload))
+	r := bytes.NewReader(frame)
+	c := &Connection{ctx: context.Background()}
+	dp := NewDataPack()
+	headData := make([]byte, dp.GetHeadLen())
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame)
+		req, err := c.readRequest(r, dp, headData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// 模拟处理链结束后的归还
+		releaseRequest(req)
+	}
+}
+
+// TestDispatchAllocs 检查消息放入 worker 任务队列的路径没有内存分配
+func TestDispatchAllocs(t *testing.T) {
+	mh := NewMsgHandle()
+	mh.AddRouter(1, &countRouter{})
+	w := &worker{queue: newLanes(1), local: newLanes(1), stats: &workerStats{}}
+	mh.workers = []*worker{w}
+
+	request := NewRequest(&Connection{ctx: context.Background()}, NewMsgPackage(1, benchPayload))
+	allocs := testing.AllocsPerRun(1000, func() {
+		mh.SendMsgToTaskQueue(request)
+		<-w.queue[laneNormal]
+	})
+	if allocs != 0 {
+		t.Fatalf("dispatch allocs = %v, want 0", allocs)
+	}
+}
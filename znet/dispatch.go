@@ -0,0 +1,169 @@
+package znet
+
+import (
+	"sync/atomic"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// 消息的分发模式
+const (
+	// DispatchHash 按 ConnID % worker 数量把连接固定分配给一个 worker, 为默认模式.
+	// 工作池为空时每条消息启动一个 goroutine 处理, 不保证连接内消息的顺序
+	DispatchHash = "hash"
+	// DispatchOrdered 每个连接拥有一个串行的消息队列 (mailbox), 由共享工作池中的任意 worker 处理,
+	// 同一时刻最多一个 worker 处理同一个连接, 因此无论工作池大小都保证连接内消息的顺序;
+	// 连接不固定在某个 worker 上, 每次最多连续处理 mailboxQuantum 条消息后让出 worker
+	DispatchOrdered = "ordered"
+	// DispatchTick 消息不立即处理, 而是按到达顺序收集到当前 tick 的 bucket 中, 由 tick goroutine 以 tick.interval
+	// 的固定频率把每个 tick 的全部消息交给 OnTick 回调, 适用于权威服务器的游戏循环. 定时任务, 登录消息以及未设置
+	// OnTick 时的消息在 tick goroutine 中按顺序交给路由处理
+	DispatchTick = "tick"
+)
+
+// mailboxQuantum 为 worker 每次调度连续处理同一连接的最大消息数, 防止一个繁忙的连接独占 worker
+const mailboxQuantum = 16
+
+// mailbox 为 DispatchOrdered 模式下连接的串行消息队列
+type mailbox struct {
+	queue     lanes       // 按优先级划分的等待处理的消息
+	skipped   int         // 连续优先处理高优先级消息的次数, 只由正在处理 mailbox 的 worker 读写
+	scheduled atomic.Bool // 是否已在运行队列中或正在被 worker 处理
+}
+
+// mailboxOf 获取请求所属连接的 mailbox, 连接不是本包的 Connection 时返回 nil
+func mailboxOf(request ziface.IRequest) *mailbox {
+	c, ok := request.GetConnection().(*Connection)
+	if !ok {
+		return nil
+	}
+	c.mailboxOnce.Do(func() {
+		c.mailbox = &mailbox{queue: newLanes(max(settings.Conf.MaxWorkerTaskLen, 1))}
+	})
+	return c.mailbox
+}
+
+// sendToMailbox 将消息放入连接 mailbox 的 lane 通道, mailbox 尚未被调度时交给工作池
+func (mh *MsgHandle) sendToMailbox(request ziface.IRequest, lane int) {
+	mb := mailboxOf(request)
+	if mb == nil {
+		go mh.DoMsgHandler(request)
+		return
+	}
+
+	select {
+	case mb.queue[lane] <- request:
+		mh.wake(mb)
+	default:
+		// mailbox 已满, 先确保其已被调度再按背压策略处理, 否则 pause 策略下没有 worker 会取出消息.
+		// mailbox 属于连接而不属于某个 worker, 等待期间无需也不能持有 poolLock
+		mh.wake(mb)
+		mh.queueFull(request, func() bool {
+			select {
+			case mb.queue[lane] <- request:
+				return true
+			case <-request.GetConnection().Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// wake 在 mailbox 尚未被调度时将其交给工作池
+func (mh *MsgHandle) wake(mb *mailbox) {
+	if mb.scheduled.CompareAndSwap(false, true) {
+		mh.poolLock.RLock()
+		mh.schedule(mb)
+		mh.poolLock.RUnlock()
+	}
+}
+
+// schedule 将 mailbox 交给工作池, 工作池为空时启动一个 goroutine 处理, 调用方须持有 poolLock
+func (mh *MsgHandle) schedule(mb *mailbox) {
+	if len(mh.workers) == 0 {
+		go mh.drain(nil, mb)
+		return
+	}
+	mh.enqueueMailbox(mb)
+}
+
+// enqueueMailbox 将 mailbox 放入运行队列, 不会阻塞: 运行队列的容量按创建时的 max_conn 确定,
+// max_conn 热更新调大后可能不足, 此时放入溢出列表, 由 worker 优先取出
+func (mh *MsgHandle) enqueueMailbox(mb *mailbox) {
+	select {
+	case mh.runQueue <- mb:
+	default:
+		mh.overflowLock.Lock()
+		mh.overflow = append(mh.overflow, mb)
+		mh.overflowLock.Unlock()
+	}
+}
+
+// popOverflow 取出溢出列表中最早的 mailbox, 列表为空时返回 nil
+func (mh *MsgHandle) popOverflow() *mailbox {
+	mh.overflowLock.Lock()
+	defer mh.overflowLock.Unlock()
+	if len(mh.overflow) == 0 {
+		return nil
+	}
+	mb := mh.overflow[0]
+	mh.overflow[0] = nil
+	mh.overflow = mh.overflow[1:]
+	return mb
+}
+
+// runQueueLen 返回等待 worker 处理的 mailbox 数量, 包括溢出列表中的 mailbox
+func (mh *MsgHandle) runQueueLen() int {
+	mh.overflowLock.Lock()
+	defer mh.overflowLock.Unlock()
+	return len(mh.runQueue) + len(mh.overflow)
+}
+
+// drain 由 worker w 依次处理 mailbox 中的消息, 每处理 mailboxQuantum 条后重新排队, 让出 worker.
+// 工作池为空时 w 为 nil
+func (mh *MsgHandle) drain(w *worker, mb *mailbox) {
+	for i := 1; ; i++ {
+		request, starved := popLanes(&mb.skipped, &mb.queue)
+		if request == nil {
+			// 队列已空, 取消调度; 若取消前又有消息进入, 重新取得调度权继续处理, 避免消息无人处理
+			mb.scheduled.Store(false)
+			if mb.queue.len() == 0 || !mb.scheduled.CompareAndSwap(false, true) {
+				return
+			}
+			continue
+		}
+		if starved && w != nil {
+			w.stats.starved.Add(1)
+		}
+		mh.process(w, request)
+
+		if i%mailboxQuantum == 0 && mh.poolSize.Load() > 0 {
+			mh.enqueueMailbox(mb)
+			return
+		}
+	}
+}
+
+// startOrderedWorker 启动一个从运行队列中取出 mailbox 处理的 worker. 溢出列表中的 mailbox 进入时运行队列已满,
+// 比运行队列中的 mailbox 等待得更久, 因此优先处理
+func (mh *MsgHandle) startOrderedWorker(w *worker) {
+	defer close(w.done)
+	mh.logger.Debug("worker started", "workerID", w.id, "mode", DispatchOrdered)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		if mb := mh.popOverflow(); mb != nil {
+			mh.drain(w, mb)
+			continue
+		}
+		select {
+		case <-w.stop:
+			return
+		case mb := <-mh.runQueue:
+			mh.drain(w, mb)
+		}
+	}
+}
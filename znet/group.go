@@ -0,0 +1,98 @@
+package znet
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"zinx/ziface"
+)
+
+// RouteGroup 为一段连续 msgId 的路由分组. 分组按 msgId 范围生效, 范围内的消息,
+// 无论通过分组还是 Server.AddRouter 注册, 都会经过分组的中间件
+type RouteGroup struct {
+	mh         *MsgHandle
+	start, end uint32
+
+	middlewares  atomic.Pointer[[]ziface.Middleware] // 中间件, 修改时整体替换
+	defaultRoute atomic.Pointer[ziface.RouteInfo]    // 组内未注册 msgId 的默认路由, 可以为 nil
+}
+
+var _ ziface.IRouteGroup = (*RouteGroup)(nil)
+
+// Group 创建包含 msgId 范围 [start, end] 的路由分组, 范围与已有分组重叠时 panic
+func (mh *MsgHandle) Group(start, end uint32) ziface.IRouteGroup {
+	if start > end {
+		panic(fmt.Sprintf("invalid group range [%d, %d]", start, end))
+	}
+	g := &RouteGroup{mh: mh, start: start, end: end}
+	g.middlewares.Store(&[]ziface.Middleware{})
+
+	mh.routesLock.Lock()
+	defer mh.routesLock.Unlock()
+	groups := slices.Clone(*mh.groups.Load())
+	for _, other := range groups {
+		if start <= other.end && other.start <= end {
+			panic(fmt.Sprintf("group range [%d, %d] overlaps [%d, %d]", start, end, other.start, other.end))
+		}
+	}
+	groups = append(groups, g)
+	slices.SortFunc(groups, func(a, b *RouteGroup) int { return cmp.Compare(a.start, b.start) })
+	mh.groups.Store(&groups)
+	return g
+}
+
+// group 查找包含 msgId 的路由分组, 不存在时返回 nil
+func (mh *MsgHandle) group(msgId uint32) *RouteGroup {
+	groups := *mh.groups.Load()
+	i, found := slices.BinarySearchFunc(groups, msgId, func(g *RouteGroup, id uint32) int { return cmp.Compare(g.start, id) })
+	if found {
+		return groups[i]
+	}
+	if i > 0 && groups[i-1].end >= msgId {
+		return groups[i-1]
+	}
+	return nil
+}
+
+// Range 返回分组包含的 msgId 范围
+func (g *RouteGroup) Range() (start, end uint32) {
+	return g.start, g.end
+}
+
+// Use 添加中间件, 先添加的中间件在外层, 对之后分发的消息生效
+func (g *RouteGroup) Use(middlewares ...ziface.Middleware) {
+	g.mh.routesLock.Lock()
+	defer g.mh.routesLock.Unlock()
+	mws := append(slices.Clone(*g.middlewares.Load()), middlewares...)
+	g.middlewares.Store(&mws)
+}
+
+// AddRouter 在分组内注册路由, msgId 不在分组范围内或已经注册时 panic
+func (g *RouteGroup) AddRouter(msgId uint32, router ziface.IRouter, opts ...ziface.RouteOption) {
+	if msgId < g.start || msgId > g.end {
+		panic(fmt.Sprintf("msgId %d out of group range [%d, %d]", msgId, g.start, g.end))
+	}
+	g.mh.AddRouter(msgId, router, opts...)
+}
+
+// SetDefault 设置组内未注册 msgId 的默认处理逻辑, router 为 nil 时取消.
+// 默认路由不对应某个具体的 msgId, 其 RouteInfo 以 IsDefault 标记, 分发时记录实际处理的 msgId
+func (g *RouteGroup) SetDefault(router ziface.IRouter, opts ...ziface.RouteOption) {
+	if router == nil {
+		g.defaultRoute.Store(nil)
+		return
+	}
+	route := g.mh.newRoute(0, router, opts)
+	route.IsDefault = true
+	g.defaultRoute.Store(route)
+}
+
+// wrap 以分组的中间件包装 handler
+func (g *RouteGroup) wrap(handler ziface.HandlerFunc) ziface.HandlerFunc {
+	mws := *g.middlewares.Load()
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
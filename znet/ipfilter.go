@@ -0,0 +1,126 @@
+package znet
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"zinx/settings"
+)
+
+// IPFilter 在 accept 时按照黑白名单与运行时封禁决定是否接受连接
+type IPFilter struct {
+	lock  sync.RWMutex
+	allow []*net.IPNet         // 白名单, 为空表示不限制
+	deny  []*net.IPNet         // 黑名单, 优先于白名单
+	bans  map[string]time.Time // 运行时封禁的 IP 及解封时间, 零值表示永久封禁
+}
+
+// NewIPFilter 根据 settings.Conf 中的 ip_allow, ip_deny 创建过滤器, 名单解析失败时返回空名单与错误
+func NewIPFilter() (*IPFilter, error) {
+	f := &IPFilter{bans: make(map[string]time.Time)}
+	return f, f.Reload()
+}
+
+// Reload 重新加载 settings.Conf 中的黑白名单, 解析失败时保留原有名单
+func (f *IPFilter) Reload() error {
+	allow, err := parseCIDRs(settings.Conf.IPAllow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseCIDRs(settings.Conf.IPDeny)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.allow, f.deny = allow, deny
+	return nil
+}
+
+// Allowed 判断来自 ip 的连接是否可以接受
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	f.lock.RLock()
+	until, banned := f.bans[ip.String()]
+	allow, deny := f.allow, f.deny
+	f.lock.RUnlock()
+
+	if banned {
+		if until.IsZero() || time.Now().Before(until) {
+			return false
+		}
+		// 封禁已过期, 顺手清理
+		f.expire(ip, until)
+	}
+
+	if ipInNets(ip, deny) {
+		return false
+	}
+	return len(allow) == 0 || ipInNets(ip, allow)
+}
+
+// Ban 封禁 ip, duration <= 0 表示永久封禁
+func (f *IPFilter) Ban(ip net.IP, duration time.Duration) {
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.bans[ip.String()] = until
+}
+
+// Unban 解除对 ip 的封禁
+func (f *IPFilter) Unban(ip net.IP) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.bans, ip.String())
+}
+
+// expire 清理 ip 已过期的封禁, 封禁期间被重新 Ban 时解封时间已经改变, 保留新的封禁
+func (f *IPFilter) expire(ip net.IP, until time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := ip.String()
+	if current, ok := f.bans[key]; ok && current.Equal(until) {
+		delete(f.bans, key)
+	}
+}
+
+// parseCIDRs 解析 CIDR 列表, 单个 IP 按 /32 或 /128 处理
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, errors.New("invalid ip: " + item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,96 @@
+package znet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"zinx/settings"
+)
+
+func TestIPFilter(t *testing.T) {
+	settings.Conf.IPAllow = []string{"10.0.0.0/8", "192.168.1.7"}
+	settings.Conf.IPDeny = []string{"10.0.0.13"}
+	defer func() { settings.Conf.IPAllow, settings.Conf.IPDeny = nil, nil }()
+
+	f, err := NewIPFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.0.13":   false, // 黑名单优先
+		"192.168.1.7": true,
+		"192.168.1.8": false, // 不在白名单中
+	} {
+		if got := f.Allowed(net.ParseIP(ip)); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	ip := net.ParseIP("10.1.2.3")
+	f.Ban(ip, 50*time.Millisecond)
+	if f.Allowed(ip) {
+		t.Fatal("banned ip allowed")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !f.Allowed(ip) {
+		t.Fatal("ban not expired")
+	}
+
+	// 清理过期封禁前 ip 被重新封禁, 新的封禁不能被清理掉
+	expired := time.Now().Add(-time.Second)
+	f.bans[ip.String()] = expired
+	f.Ban(ip, time.Hour)
+	f.expire(ip, expired)
+	if f.Allowed(ip) {
+		t.Fatal("new ban removed by expiry of the old one")
+	}
+	f.Unban(ip)
+
+	// 热更新后白名单失效
+	settings.Conf.IPAllow = nil
+	if err := f.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Allowed(net.ParseIP("192.168.1.8")) {
+		t.Fatal("reload not applied")
+	}
+}
+
+func TestMaxConnPerIP(t *testing.T) {
+	listenTest(t)
+	settings.Conf.MaxConnPerIP = 1
+	defer func() { settings.Conf.MaxConnPerIP = 0 }()
+
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+
+	first := dialTest(t, s)
+	defer first.Close()
+	second := dialTest(t, s)
+	defer second.Close()
+
+	// 同一 IP 的第二个连接被服务端关闭, 第一个连接保持
+	buf := make([]byte, 1)
+	second.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := second.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("second conn read err = %v, want EOF", err)
+	}
+	first.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := first.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("first conn read err = %v, want deadline exceeded", err)
+	}
+	if n := s.GetConnMgr().Len(); n != 1 {
+		t.Errorf("conns = %d, want 1", n)
+	}
+	m := s.(*Server).metrics
+	m.rejectedLock.Lock()
+	defer m.rejectedLock.Unlock()
+	if n := m.rejected["max_conn_per_ip"]; n != 1 {
+		t.Errorf("rejected max_conn_per_ip = %d, want 1", n)
+	}
+}
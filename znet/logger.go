@@ -0,0 +1,35 @@
+package znet
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// NewLogger 根据 settings.Conf 中的 log_level (debug, info, warn, error) 与 log_format (text, json)
+// 创建基于 log/slog 的日志, 输出到标准错误
+func NewLogger() ziface.ILogger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(settings.Conf.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if settings.Conf.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	return slog.New(handler)
+}
+
+// addrString 将地址转换为日志中使用的字符串, 地址为 nil 时返回空字符串
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
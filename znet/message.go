@@ -6,6 +6,7 @@ type Message struct {
 	Id      uint32 // 消息的 ID
 	DataLen uint32 // 消息的长度
 	Data    []byte // 消息的内容
+	Flags   uint32 // 消息的标志位, 封包时写入包头 dataLen 字段的高 4 位
 }
 
 var _ ziface.IMessage = (*Message)(nil)
@@ -48,3 +49,13 @@ func (msg *Message) SetMsgId(msgId uint32) {
 func (msg *Message) SetData(data []byte) {
 	msg.Data = data
 }
+
+// GetFlags 获取消息的标志位
+func (msg *Message) GetFlags() uint32 {
+	return msg.Flags
+}
+
+// SetFlags 设置消息的标志位
+func (msg *Message) SetFlags(flags uint32) {
+	msg.Flags = flags
+}
@@ -0,0 +1,418 @@
+package znet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/settings"
+)
+
+// latencyBuckets 为处理耗时直方图的桶上界, 单位为秒
+var latencyBuckets = [...]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// maxMetricMsgIds 为按 msgId 统计的最大 msgId 数量, 超出的 msgId 统一计入 msg_id="other",
+// 防止客户端发送任意 msgId 使指标无限增长
+const maxMetricMsgIds = 1024
+
+// Metrics 记录 Server 的运行指标, 以 Prometheus 文本格式导出. nil 的 *Metrics 忽略所有记录
+type Metrics struct {
+	totalConns   atomic.Uint64 // 累计接受的连接数
+	unknownMsgs  atomic.Uint64 // 未注册 msgId 的消息数
+	panics       atomic.Uint64 // 处理消息时发生 panic 的次数
+	queueFull    atomic.Uint64 // 任务队列已满的次数
+	taskDrops    atomic.Uint64 // 因任务队列已满被丢弃的消息数
+	timeouts     atomic.Uint64 // 路由处理超时的次数
+	slowHandlers atomic.Uint64 // 处理耗时超过 slow_handler_threshold 的次数
+	ticks        atomic.Uint64 // 执行的 tick 数
+	tickOverruns atomic.Uint64 // 耗时超过 tick 间隔的 tick 数
+	tickSkipped  atomic.Uint64 // 因落后而跳过的 tick 数
+	tickBusy     atomic.Int64  // tick 的累计耗时, 单位为纳秒
+	tickInputs   atomic.Uint64 // 各 tick 收集的消息总数
+	rejectedLock sync.Mutex
+	rejected     map[string]uint64 // 按原因统计的被拒绝的连接数
+
+	msgLock sync.RWMutex
+	msgs    map[uint32]*msgMetrics // 按 msgId 统计的消息指标
+	other   msgMetrics             // 超出 maxMetricMsgIds 的 msgId 的消息指标
+}
+
+// msgMetrics 为单个 msgId 的消息指标
+type msgMetrics struct {
+	msgsIn   atomic.Uint64
+	bytesIn  atomic.Uint64
+	msgsOut  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	latencyCount   atomic.Uint64
+	latencySum     atomic.Uint64 // 单位为纳秒
+	latencyBuckets [len(latencyBuckets)]atomic.Uint64
+}
+
+// NewMetrics 创建指标
+func NewMetrics() *Metrics {
+	return &Metrics{
+		rejected: make(map[string]uint64),
+		msgs:     make(map[uint32]*msgMetrics),
+	}
+}
+
+// msg 获取 msgId 对应的指标, 不存在时创建
+func (m *Metrics) msg(msgId uint32) *msgMetrics {
+	m.msgLock.RLock()
+	mm, ok := m.msgs[msgId]
+	m.msgLock.RUnlock()
+	if ok {
+		return mm
+	}
+
+	m.msgLock.Lock()
+	defer m.msgLock.Unlock()
+	if mm, ok = m.msgs[msgId]; ok {
+		return mm
+	}
+	if len(m.msgs) >= maxMetricMsgIds {
+		return &m.other
+	}
+	mm = &msgMetrics{}
+	m.msgs[msgId] = mm
+	return mm
+}
+
+// connAccepted 记录一个被接受的连接
+func (m *Metrics) connAccepted() {
+	if m == nil {
+		return
+	}
+	m.totalConns.Add(1)
+}
+
+// connRejected 记录一个因 reason 被拒绝的连接
+func (m *Metrics) connRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejectedLock.Lock()
+	m.rejected[reason]++
+	m.rejectedLock.Unlock()
+}
+
+// msgIn 记录一条收到的消息, n 为消息帧在网络上的字节数
+func (m *Metrics) msgIn(msgId uint32, n int) {
+	if m == nil {
+		return
+	}
+	mm := m.msg(msgId)
+	mm.msgsIn.Add(1)
+	mm.bytesIn.Add(uint64(n))
+}
+
+// msgOut 记录一条发出的消息, n 为消息帧在网络上的字节数
+func (m *Metrics) msgOut(msgId uint32, n int) {
+	if m == nil {
+		return
+	}
+	mm := m.msg(msgId)
+	mm.msgsOut.Add(1)
+	mm.bytesOut.Add(uint64(n))
+}
+
+// handled 记录一次消息处理链的耗时
+func (m *Metrics) handled(msgId uint32, d time.Duration) {
+	if m == nil {
+		return
+	}
+	mm := m.msg(msgId)
+	mm.latencyCount.Add(1)
+	mm.latencySum.Add(uint64(d))
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			mm.latencyBuckets[i].Add(1)
+			break
+		}
+	}
+}
+
+// unknownMsg 记录一条未注册 msgId 的消息
+func (m *Metrics) unknownMsg() {
+	if m == nil {
+		return
+	}
+	m.unknownMsgs.Add(1)
+}
+
+// panicked 记录一次处理消息时发生的 panic
+func (m *Metrics) panicked() {
+	if m == nil {
+		return
+	}
+	m.panics.Add(1)
+}
+
+// taskQueueFull 记录一次任务队列已满
+func (m *Metrics) taskQueueFull() {
+	if m == nil {
+		return
+	}
+	m.queueFull.Add(1)
+}
+
+// taskDropped 记录一条因任务队列已满被丢弃的消息
+func (m *Metrics) taskDropped() {
+	if m == nil {
+		return
+	}
+	m.taskDrops.Add(1)
+}
+
+// handlerTimedOut 记录一次路由处理超时
+func (m *Metrics) handlerTimedOut() {
+	if m == nil {
+		return
+	}
+	m.timeouts.Add(1)
+}
+
+// slowHandler 记录一次慢处理
+func (m *Metrics) slowHandler() {
+	if m == nil {
+		return
+	}
+	m.slowHandlers.Add(1)
+}
+
+// tickDone 记录一个 tick 的耗时与收集的消息数
+func (m *Metrics) tickDone(d time.Duration, inputs int, overrun bool, skipped uint64) {
+	if m == nil {
+		return
+	}
+	m.ticks.Add(1)
+	m.tickBusy.Add(int64(d))
+	m.tickInputs.Add(uint64(inputs))
+	m.tickSkipped.Add(skipped)
+	if overrun {
+		m.tickOverruns.Add(1)
+	}
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式导出指标的 http.Handler
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	})
+}
+
+// serveMetrics 在 metrics_addr 上启动指标的 HTTP 服务, 未配置时不启动
+func (s *Server) serveMetrics() {
+	addr := settings.Conf.MetricsAddr
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	s.metricsServer = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		s.logger.Info("metrics server listening", "addr", addr)
+		if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("metrics server failed", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// writeMetrics 将 Server 的全部指标以 Prometheus 文本格式写入 w
+func (s *Server) writeMetrics(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	m := s.metrics
+
+	writeHeader(bw, "zinx_connections_active", "gauge", "Number of live connections.")
+	fmt.Fprintf(bw, "zinx_connections_active %d\n", s.ConnMgr.Len())
+	writeHeader(bw, "zinx_connections_total", "counter", "Total number of accepted connections.")
+	fmt.Fprintf(bw, "zinx_connections_total %d\n", m.totalConns.Load())
+
+	writeHeader(bw, "zinx_connections_rejected_total", "counter", "Total number of rejected connections by reason.")
+	m.rejectedLock.Lock()
+	reasons := make([]string, 0, len(m.rejected))
+	for reason := range m.rejected {
+		reasons = append(reasons, reason)
+	}
+	slices.Sort(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(bw, "zinx_connections_rejected_total{reason=%q} %d\n", reason, m.rejected[reason])
+	}
+	m.rejectedLock.Unlock()
+
+	writeHeader(bw, "zinx_unknown_messages_total", "counter", "Total number of messages with an unregistered msgId.")
+	fmt.Fprintf(bw, "zinx_unknown_messages_total %d\n", m.unknownMsgs.Load())
+	writeHeader(bw, "zinx_handler_panics_total", "counter", "Total number of panics recovered in handlers.")
+	fmt.Fprintf(bw, "zinx_handler_panics_total %d\n", m.panics.Load())
+	writeHeader(bw, "zinx_task_queue_full_total", "counter", "Total number of times a request found its task queue full.")
+	fmt.Fprintf(bw, "zinx_task_queue_full_total %d\n", m.queueFull.Load())
+	writeHeader(bw, "zinx_task_queue_dropped_total", "counter", "Total number of requests dropped because the task queue was full.")
+	fmt.Fprintf(bw, "zinx_task_queue_dropped_total %d\n", m.taskDrops.Load())
+	writeHeader(bw, "zinx_handler_timeouts_total", "counter", "Total number of handler chains that exceeded their route timeout.")
+	fmt.Fprintf(bw, "zinx_handler_timeouts_total %d\n", m.timeouts.Load())
+	writeHeader(bw, "zinx_slow_handlers_total", "counter", "Total number of handler chains slower than slow_handler_threshold.")
+	fmt.Fprintf(bw, "zinx_slow_handlers_total %d\n", m.slowHandlers.Load())
+	writeHeader(bw, "zinx_scheduled_tasks", "gauge", "Number of scheduled tasks waiting in the timing wheel.")
+	fmt.Fprintf(bw, "zinx_scheduled_tasks %d\n", s.scheduler.Len())
+
+	s.writeMsgMetrics(bw)
+	s.writeQueueMetrics(bw)
+}
+
+// writeMsgMetrics 写入按 msgId 统计的消息与处理耗时指标
+func (s *Server) writeMsgMetrics(w io.Writer) {
+	m := s.metrics
+	m.msgLock.RLock()
+	ids := make([]uint32, 0, len(m.msgs))
+	for id := range m.msgs {
+		ids = append(ids, id)
+	}
+	m.msgLock.RUnlock()
+	slices.Sort(ids)
+
+	type labeled struct {
+		label string
+		mm    *msgMetrics
+	}
+	all := make([]labeled, 0, len(ids)+1)
+	for _, id := range ids {
+		all = append(all, labeled{strconv.FormatUint(uint64(id), 10), m.msg(id)})
+	}
+	all = append(all, labeled{"other", &m.other})
+
+	counters := []struct {
+		name, help string
+		value      func(mm *msgMetrics) uint64
+	}{
+		{"zinx_messages_in_total", "Total number of received messages by msgId.", func(mm *msgMetrics) uint64 { return mm.msgsIn.Load() }},
+		{"zinx_bytes_in_total", "Total number of received frame bytes by msgId.", func(mm *msgMetrics) uint64 { return mm.bytesIn.Load() }},
+		{"zinx_messages_out_total", "Total number of sent messages by msgId.", func(mm *msgMetrics) uint64 { return mm.msgsOut.Load() }},
+		{"zinx_bytes_out_total", "Total number of sent frame bytes by msgId.", func(mm *msgMetrics) uint64 { return mm.bytesOut.Load() }},
+	}
+	for _, c := range counters {
+		writeHeader(w, c.name, "counter", c.help)
+		for _, l := range all {
+			if v := c.value(l.mm); v > 0 || l.label != "other" {
+				fmt.Fprintf(w, "%s{msg_id=%q} %d\n", c.name, l.label, v)
+			}
+		}
+	}
+
+	writeHeader(w, "zinx_handler_duration_seconds", "histogram", "Duration of the router handler chain by msgId.")
+	for _, l := range all {
+		count := l.mm.latencyCount.Load()
+		if count == 0 {
+			continue
+		}
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += l.mm.latencyBuckets[i].Load()
+			fmt.Fprintf(w, "zinx_handler_duration_seconds_bucket{msg_id=%q,le=%q} %d\n",
+				l.label, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "zinx_handler_duration_seconds_bucket{msg_id=%q,le=\"+Inf\"} %d\n", l.label, count)
+		fmt.Fprintf(w, "zinx_handler_duration_seconds_sum{msg_id=%q} %g\n",
+			l.label, time.Duration(l.mm.latencySum.Load()).Seconds())
+		fmt.Fprintf(w, "zinx_handler_duration_seconds_count{msg_id=%q} %d\n", l.label, count)
+	}
+}
+
+// writeTickMetrics 写入 DispatchTick 模式的 tick 指标
+func writeTickMetrics(w io.Writer, m *Metrics, mh *MsgHandle) {
+	mh.tickLock.Lock()
+	pending := len(mh.tickBucket)
+	mh.tickLock.Unlock()
+	writeHeader(w, "zinx_tick_bucket_length", "gauge", "Number of requests collected for the next tick.")
+	fmt.Fprintf(w, "zinx_tick_bucket_length %d\n", pending)
+	writeHeader(w, "zinx_ticks_total", "counter", "Total number of ticks run.")
+	fmt.Fprintf(w, "zinx_ticks_total %d\n", m.ticks.Load())
+	writeHeader(w, "zinx_tick_overruns_total", "counter", "Total number of ticks that took longer than the tick interval.")
+	fmt.Fprintf(w, "zinx_tick_overruns_total %d\n", m.tickOverruns.Load())
+	writeHeader(w, "zinx_ticks_skipped_total", "counter", "Total number of ticks skipped because the loop fell behind.")
+	fmt.Fprintf(w, "zinx_ticks_skipped_total %d\n", m.tickSkipped.Load())
+	// tick 的平均耗时即 rate(zinx_tick_duration_seconds_total[1m]) / rate(zinx_ticks_total[1m])
+	writeHeader(w, "zinx_tick_duration_seconds_total", "counter", "Total time spent running ticks.")
+	fmt.Fprintf(w, "zinx_tick_duration_seconds_total %g\n", time.Duration(m.tickBusy.Load()).Seconds())
+	writeHeader(w, "zinx_tick_requests_total", "counter", "Total number of requests collected into ticks.")
+	fmt.Fprintf(w, "zinx_tick_requests_total %d\n", m.tickInputs.Load())
+}
+
+// writeQueueMetrics 写入 worker 任务队列与连接发送队列的指标
+func (s *Server) writeQueueMetrics(w io.Writer) {
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		workers := *mh.workerList.Load()
+		writeHeader(w, "zinx_worker_pool_size", "gauge", "Number of workers in the pool.")
+		fmt.Fprintf(w, "zinx_worker_pool_size %d\n", len(workers))
+		writeHeader(w, "zinx_worker_queue_length", "gauge", "Number of requests waiting in each worker task queue by priority lane.")
+		for _, wk := range workers {
+			for lane, name := range laneNames {
+				fmt.Fprintf(w, "zinx_worker_queue_length{worker_id=\"%d\",lane=%q} %d\n",
+					wk.id, name, len(wk.queue[lane])+len(wk.local[lane]))
+			}
+		}
+		if mh.dispatchMode == DispatchTick {
+			writeTickMetrics(w, s.metrics, mh)
+		}
+		if mh.dispatchMode == DispatchOrdered {
+			writeHeader(w, "zinx_worker_run_queue_length", "gauge", "Number of connection mailboxes waiting for a worker.")
+			fmt.Fprintf(w, "zinx_worker_run_queue_length %d\n", mh.runQueueLen())
+		}
+		// worker 的利用率即 rate(zinx_worker_busy_seconds_total[1m])
+		writeHeader(w, "zinx_worker_busy_seconds_total", "counter", "Total time each worker spent handling requests.")
+		for _, wk := range workers {
+			fmt.Fprintf(w, "zinx_worker_busy_seconds_total{worker_id=\"%d\"} %g\n",
+				wk.id, time.Duration(wk.stats.busy.Load()).Seconds())
+		}
+		writeHeader(w, "zinx_worker_handled_total", "counter", "Total number of requests handled by each worker.")
+		for _, wk := range workers {
+			fmt.Fprintf(w, "zinx_worker_handled_total{worker_id=\"%d\"} %d\n", wk.id, wk.stats.handled.Load())
+		}
+		writeHeader(w, "zinx_worker_stolen_total", "counter", "Total number of unordered requests each worker stole from others.")
+		for _, wk := range workers {
+			fmt.Fprintf(w, "zinx_worker_stolen_total{worker_id=\"%d\"} %d\n", wk.id, wk.stats.stolen.Load())
+		}
+		writeHeader(w, "zinx_worker_starvation_total", "counter", "Total number of times a worker handled a lower priority request ahead of higher priority ones to prevent starvation.")
+		for _, wk := range workers {
+			fmt.Fprintf(w, "zinx_worker_starvation_total{worker_id=\"%d\"} %d\n", wk.id, wk.stats.starved.Load())
+		}
+	}
+
+	// 发送队列按连接聚合, 避免每个连接一条时间序列
+	var sum, max, full int
+	for _, conn := range s.ConnMgr.GetAll() {
+		c, ok := conn.(*Connection)
+		if !ok {
+			continue
+		}
+		n := len(c.msgBuffChan)
+		sum += n
+		if n > max {
+			max = n
+		}
+		if n > 0 && n == cap(c.msgBuffChan) {
+			full++
+		}
+	}
+	writeHeader(w, "zinx_send_queue_capacity", "gauge", "Capacity of each connection's buffered send queue.")
+	fmt.Fprintf(w, "zinx_send_queue_capacity %d\n", settings.Conf.MaxMsgChanLen)
+	writeHeader(w, "zinx_send_queue_length_sum", "gauge", "Total number of messages waiting in all connections' send queues.")
+	fmt.Fprintf(w, "zinx_send_queue_length_sum %d\n", sum)
+	writeHeader(w, "zinx_send_queue_length_max", "gauge", "Longest send queue among live connections.")
+	fmt.Fprintf(w, "zinx_send_queue_length_max %d\n", max)
+	writeHeader(w, "zinx_send_queue_full_connections", "gauge", "Number of connections whose send queue is full.")
+	fmt.Fprintf(w, "zinx_send_queue_full_connections %d\n", full)
+}
+
+// writeHeader 写入一个指标的 HELP 与 TYPE 行
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
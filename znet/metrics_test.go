@@ -0,0 +1,47 @@
+package znet
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+type panicRouter struct {
+	BaseRouter
+}
+
+func (r *panicRouter) Handle(request ziface.IRequest) {
+	panic("boom")
+}
+
+func TestMetrics(t *testing.T) {
+	s := NewServer().(*Server)
+	s.AddRouter(1, &panicRouter{})
+	mh := s.msgHandler.(*MsgHandle)
+
+	conn := &Connection{ctx: context.Background(), server: s}
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1, nil)))
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(2, nil)))
+	s.metrics.msgIn(1, 12)
+	s.metrics.handled(1, 3*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"zinx_handler_panics_total 1\n",
+		"zinx_unknown_messages_total 1\n",
+		`zinx_messages_in_total{msg_id="1"} 1` + "\n",
+		`zinx_bytes_in_total{msg_id="1"} 12` + "\n",
+		`zinx_handler_duration_seconds_bucket{msg_id="1",le="10"} 2` + "\n",
+		`zinx_handler_duration_seconds_count{msg_id="1"} 2` + "\n",
+		"zinx_connections_active 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
@@ -1,84 +1,305 @@
 package znet
 
 import (
+	"cmp"
 	"fmt"
+	"log/slog"
+	"maps"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 	"zinx/settings"
 	"zinx/ziface"
 )
 
+// routeTable 为 MsgId 到路由注册信息的映射, 发布后不再修改
+type routeTable map[uint32]*ziface.RouteInfo
+
 type MsgHandle struct {
-	Apis           map[uint32]ziface.IRouter // map 存放每个 MsgId 对应的处理方法
-	WorkerPoolSize uint32                    // 业务工作 worker 池的数量
-	TaskQueue      []chan ziface.IRequest    // worker 负责取任务的消息队列
+	WorkerPoolSize uint32                        // 启动工作池时的 worker 数量, 运行中通过 SetWorkerPoolSize 调整
+	routes         atomic.Pointer[routeTable]    // 每个 MsgId 对应的路由注册信息, 修改时整体复制后替换 (copy-on-write)
+	routesLock     sync.Mutex                    // 串行化路由表与路由分组的修改
+	groups         atomic.Pointer[[]*RouteGroup] // 按 msgId 范围排序的路由分组
+	dispatchMode   string                        // 分发模式, DispatchHash 或 DispatchOrdered
+	runQueue       chan *mailbox                 // DispatchOrdered 模式下等待 worker 处理的 mailbox
+	overflow       []*mailbox                    // 运行队列已满时等待 worker 处理的 mailbox, 由 overflowLock 保护
+	overflowLock   sync.Mutex
+
+	poolLock    sync.RWMutex              // 分发消息选择队列时持读锁 (等待队列空位时不持有), 调整工作池大小时持写锁
+	workers     []*worker                 // 当前的 worker, 由 poolLock 保护
+	resizeLock  sync.Mutex                // 保证同一时刻只有一次工作池调整
+	workerList  atomic.Pointer[[]*worker] // 当前 worker 的快照, 供窃取任务与导出指标时无锁读取
+	poolSize    atomic.Uint32             // 当前的 worker 数量
+	stealSignal chan struct{}             // 有可窃取的无序消息时通知空闲的 worker
+
+	tickLock     sync.Mutex
+	tickBucket   []ziface.IRequest // DispatchTick 模式下当前 tick 收集的消息, 由 tickLock 保护
+	tickSwap     chan struct{}     // 每个 tick 取走 bucket 时关闭并替换, 供 pause 策略等待, 由 tickLock 保护
+	tickStarted  atomic.Bool
+	tickStop     chan struct{}
+	tickStopOnce sync.Once
+
+	onQueueFull func(conn ziface.IConnection, msgId uint32)                        // 任务队列已满时的 hook 函数
+	onTimeout   func(conn ziface.IConnection, msgId uint32, timeout time.Duration) // 路由处理超时的 hook 函数
+	onTick      func(tick uint64, requests []ziface.IRequest)                      // DispatchTick 模式的 tick 回调
+
+	authenticator ziface.Authenticator // 认证方法, 为 nil 时不要求认证
+	loginMsgId    uint32               // 登录消息 ID, 该消息会先交给 authenticator 校验
+
+	logger  ziface.ILogger // 日志
+	metrics *Metrics       // 运行指标, 由 Server 设置, 为 nil 时不记录
+	tracer  ziface.ITracer // 追踪钩子, 为 nil 时不创建 span
 }
 
 var _ ziface.IMsgHandle = (*MsgHandle)(nil)
 
 func NewMsgHandle() *MsgHandle {
-	return &MsgHandle{
-		Apis:           make(map[uint32]ziface.IRouter),
+	mh := &MsgHandle{
 		WorkerPoolSize: settings.Conf.WorkerPoolSize,
-		TaskQueue:      make([]chan ziface.IRequest, settings.Conf.WorkerPoolSize),
+		logger:         slog.Default(),
+		dispatchMode:   DispatchHash,
+		stealSignal:    make(chan struct{}, 1),
+		tickSwap:       make(chan struct{}),
+		tickStop:       make(chan struct{}),
+	}
+	switch settings.Conf.DispatchMode {
+	case DispatchOrdered:
+		// 每个连接至多有一个 mailbox 等待调度, 容量不小于最大连接数时通常无需溢出列表
+		mh.dispatchMode = DispatchOrdered
+		mh.runQueue = make(chan *mailbox, max(settings.Conf.MaxConn, 1))
+	case DispatchTick:
+		mh.dispatchMode = DispatchTick
 	}
+	mh.routes.Store(&routeTable{})
+	mh.groups.Store(&[]*RouteGroup{})
+	mh.workerList.Store(&[]*worker{})
+	return mh
 }
 
-// 立即以非阻塞的方式处理消息
+// SetTracer 设置追踪钩子, 为 nil 时关闭追踪
+func (mh *MsgHandle) SetTracer(tracer ziface.ITracer) {
+	mh.tracer = tracer
+}
+
+// SetLogger 设置日志
+func (mh *MsgHandle) SetLogger(logger ziface.ILogger) {
+	mh.logger = logger
+}
+
+// 立即以非阻塞的方式处理消息, 处理链结束后请求及其数据会被归还到缓冲池
 func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
-	handler, ok := mh.Apis[request.GetMsgID()]
+	// 定时任务不经过路由, 直接执行
+	if r, ok := request.(*taskRequest); ok {
+		r.task.execute()
+		releaseRequest(r)
+		return
+	}
+
+	// 超时后仍在执行的处理链负责结束 span 并归还请求
+	span := mh.traceBegin(request)
+	release := true
+	defer func() {
+		if release {
+			span.End()
+			releaseRequest(request)
+		}
+	}()
+
+	route, group := mh.lookupRoute(request.GetMsgID())
+	ok := route != nil
+
+	// 登录消息先交给认证方法, 无论认证成功与否, 之后都交给登录消息的 Router 回复结果
+	if mh.authenticator != nil && request.GetMsgID() == mh.loginMsgId {
+		mh.authenticate(request)
+	}
+
 	if !ok {
-		fmt.Println("api msgId = ", request.GetMsgID(), " is not FOUND!")
+		span.RecordError(ErrRouteNotFound)
+		mh.metrics.unknownMsg()
+		mh.logger.Warn("api not found", "connID", request.GetConnection().GetConnID(),
+			"remote", addrString(request.GetConnection().RemoteAddr()), "msgID", request.GetMsgID())
+		return
+	}
+
+	if !mh.authorized(request, route) {
+		span.RecordError(ErrUnauthorized)
+		mh.logger.Warn("api not authorized", "connID", request.GetConnection().GetConnID(),
+			"remote", addrString(request.GetConnection().RemoteAddr()), "msgID", request.GetMsgID())
 		return
 	}
 
-	// 执行 Router 的 Handler
-	handler.PreHandle(request)
-	handler.Handle(request)
-	handler.PostHandle(request)
+	if route.Timeout > 0 {
+		release = mh.executeTimeout(request, route, group, span)
+		return
+	}
+	mh.execute(request, route, group, span)
 }
 
-// 为某条消息添加具体的处理逻辑
-func (mh *MsgHandle) AddRouter(msgId uint32, router ziface.IRouter) {
-	// 判断当前 msg 绑定的 API 处理方法是否已经存在
-	if _, ok := mh.Apis[msgId]; ok {
-		panic("repeated api, msgId = " + strconv.Itoa(int(msgId)))
+// execute 执行路由的处理链, 记录耗时并在超过 slow_handler_threshold 时记录慢处理警告
+func (mh *MsgHandle) execute(request ziface.IRequest, route *ziface.RouteInfo, group *RouteGroup, span ziface.ISpan) {
+	// Router 中的 panic 只影响当前消息, 不会使 worker 或整个进程退出
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			mh.metrics.panicked()
+			span.RecordError(fmt.Errorf("panic: %v", r))
+			mh.logger.Error("handler panic", "connID", request.GetConnection().GetConnID(),
+				"msgID", request.GetMsgID(), "panic", r, "stack", string(debug.Stack()))
+		}
+		elapsed := time.Since(start)
+		mh.metrics.handled(request.GetMsgID(), elapsed)
+		if threshold := settings.Conf.SlowHandlerThreshold; threshold > 0 && elapsed >= threshold {
+			mh.metrics.slowHandler()
+			mh.logger.Warn("slow handler", "connID", request.GetConnection().GetConnID(),
+				"msgID", request.GetMsgID(), "duration", elapsed)
+		}
+	}()
+
+	// 执行 Router 的 Handler, 属于分组的消息先经过分组的中间件
+	if group == nil {
+		mh.runRouter(request, route.Router)
+		return
 	}
+	group.wrap(func(request ziface.IRequest) {
+		mh.runRouter(request, route.Router)
+	})(request)
+}
+
+// runRouter 依次执行 Router 的 PreHandle, Handle, PostHandle
+func (mh *MsgHandle) runRouter(request ziface.IRequest, router ziface.IRouter) {
+	mh.tracePhase(request, "zinx.prehandle", router.PreHandle)
+	mh.tracePhase(request, "zinx.handle", router.Handle)
+	mh.tracePhase(request, "zinx.posthandle", router.PostHandle)
+}
 
-	// 添加 msg 与 api 的绑定关系
-	mh.Apis[msgId] = router
-	fmt.Println("Add api msgId = ", msgId)
+// 为某条消息添加具体的处理逻辑, msgId 已经注册时 panic
+func (mh *MsgHandle) AddRouter(msgId uint32, router ziface.IRouter, opts ...ziface.RouteOption) {
+	route := mh.newRoute(msgId, router, opts)
+	mh.updateRoutes(func(table routeTable) {
+		// 判断当前 msg 绑定的 API 处理方法是否已经存在
+		if _, ok := table[msgId]; ok {
+			panic("repeated api, msgId = " + strconv.Itoa(int(msgId)))
+		}
+		// 添加 msg 与 api 的绑定关系
+		table[msgId] = route
+	})
+	mh.logger.Debug("api added", "msgID", msgId)
+}
+
+// ReplaceRouter 注册或替换 msgId 的处理逻辑, 返回被替换的 Router (不存在时为 nil).
+// 已经开始处理的消息仍由原来的 Router 处理完毕
+func (mh *MsgHandle) ReplaceRouter(msgId uint32, router ziface.IRouter, opts ...ziface.RouteOption) ziface.IRouter {
+	var old ziface.IRouter
+	route := mh.newRoute(msgId, router, opts)
+	mh.updateRoutes(func(table routeTable) {
+		if prev, ok := table[msgId]; ok {
+			old = prev.Router
+		}
+		table[msgId] = route
+	})
+	mh.logger.Info("api replaced", "msgID", msgId)
+	return old
 }
 
-// StartOneWorker 启动一个 Worker 的工作流程
-func (mh *MsgHandle) StartOneWorker(workerID int, taskQueue chan ziface.IRequest) {
-	fmt.Println("Worker ID = ", workerID, " is started.")
-	for {
-		select {
-		case request := <-taskQueue:
-			mh.DoMsgHandler(request)
+// RemoveRouter 删除 msgId 的处理逻辑, 之后收到的该消息按未注册处理
+func (mh *MsgHandle) RemoveRouter(msgId uint32) error {
+	var err error
+	mh.updateRoutes(func(table routeTable) {
+		if _, ok := table[msgId]; !ok {
+			err = ErrRouteNotFound
+			return
 		}
+		delete(table, msgId)
+	})
+	if err == nil {
+		mh.logger.Info("api removed", "msgID", msgId)
 	}
+	return err
 }
 
-// StartWorkerPool 启动 worker 工作池
-func (mh *MsgHandle) StartWorkerPool() {
-	// 遍历需要启动的 worker, 依次启动
-	for i := 0; i < int(mh.WorkerPoolSize); i++ {
-		// 一个 worker 被启动时, 给当前的 worker 对应的任务队列开辟空间
-		mh.TaskQueue[i] = make(chan ziface.IRequest, settings.Conf.MaxWorkerTaskLen)
-		// 启动当前 worker, 阻塞地等待对应的任务队列是否有消息传来
-		go mh.StartOneWorker(i, mh.TaskQueue[i])
+// Routes 返回当前全部路由注册信息的快照, 按 MsgId 排序. 分组的默认路由不对应具体的 msgId, 不在其中
+func (mh *MsgHandle) Routes() []ziface.RouteInfo {
+	table := *mh.routes.Load()
+	routes := make([]ziface.RouteInfo, 0, len(table))
+	for _, route := range table {
+		routes = append(routes, *route)
+	}
+	slices.SortFunc(routes, func(a, b ziface.RouteInfo) int { return cmp.Compare(a.MsgId, b.MsgId) })
+	return routes
+}
+
+// updateRoutes 复制当前路由表交给 update 修改, 再原子地替换为修改后的路由表,
+// 因此分发消息时读取路由表无需加锁
+func (mh *MsgHandle) updateRoutes(update func(table routeTable)) {
+	mh.routesLock.Lock()
+	defer mh.routesLock.Unlock()
+
+	table := maps.Clone(*mh.routes.Load())
+	update(table)
+	mh.routes.Store(&table)
+}
+
+// newRoute 创建路由注册信息并应用路由选项. DispatchOrdered 模式下超时的处理链会与连接的后续消息并发执行,
+// 破坏该模式的顺序保证, 因此设置了 WithTimeout 时 panic
+func (mh *MsgHandle) newRoute(msgId uint32, router ziface.IRouter, opts []ziface.RouteOption) *ziface.RouteInfo {
+	route := &ziface.RouteInfo{MsgId: msgId, Router: router, ReplyMsgId: msgId}
+	for _, opt := range opts {
+		opt(route)
+	}
+	if route.Timeout > 0 && mh.dispatchMode == DispatchOrdered {
+		panic("WithTimeout is not supported in ordered dispatch mode, msgId = " + strconv.Itoa(int(msgId)))
 	}
+	return route
 }
 
-// SendMsgToTaskQueue 将消息交给 TaskQueue, 由 worker 进行处理
+// lookupRoute 查找 msgId 的路由及其所在的分组, 未注册的 msgId 交给所在分组的默认路由, 都没有时 route 为 nil
+func (mh *MsgHandle) lookupRoute(msgId uint32) (route *ziface.RouteInfo, group *RouteGroup) {
+	route = (*mh.routes.Load())[msgId]
+	group = mh.group(msgId)
+	if route == nil && group != nil {
+		if fallback := group.defaultRoute.Load(); fallback != nil {
+			// 默认路由由组内多个 msgId 共用, 复制一份记录本次实际处理的 msgId
+			served := *fallback
+			served.MsgId = msgId
+			if served.ReplyMsgId == 0 {
+				served.ReplyMsgId = msgId
+			}
+			route = &served
+		}
+	}
+	return route, group
+}
+
+// SendMsgToTaskQueue 将消息交给工作池, 由 worker 进行处理; DispatchTick 模式下放入当前 tick 的 bucket
 func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
-	// 根据 ConnID 来分配当前的连接应该由哪个 worker 负责处理
-	// 使用轮询分配法则
-
-	// 得到需要处理此条连接地 workerID
-	workerID := request.GetConnection().GetConnID() % mh.WorkerPoolSize
-	fmt.Println("Add ConnID = ", request.GetConnection().GetConnID(), " request msgID = ", request.GetMsgID(), "to workerID = ", workerID)
-	// 将请求消息发送给任务队列
-	mh.TaskQueue[workerID] <- request
+	mh.traceEnqueue(request)
+	if mh.dispatchMode == DispatchTick {
+		mh.sendToTick(request)
+		return
+	}
+	// 定时任务按普通优先级进入连接固定的队列
+	lane, unordered := laneNormal, false
+	if _, ok := request.(*taskRequest); !ok {
+		lane, unordered = mh.routeClass(request.GetMsgID())
+	}
+	if mh.dispatchMode == DispatchOrdered {
+		mh.sendToMailbox(request, lane)
+		return
+	}
+	mh.dispatch(request, lane, unordered)
+}
+
+// SetAuthenticator 设置认证方法, 此后未认证的连接只能调用白名单中的消息以及登录消息 loginMsgId.
+// 登录消息总是以最高优先级在连接内按顺序处理, 其路由的 WithPriority 与 WithUnordered 不生效
+func (mh *MsgHandle) SetAuthenticator(loginMsgId uint32, auth ziface.Authenticator) {
+	mh.loginMsgId = loginMsgId
+	mh.authenticator = auth
+}
+
+// AuthEnabled 是否设置了认证方法
+func (mh *MsgHandle) AuthEnabled() bool {
+	return mh.authenticator != nil
 }
@@ -0,0 +1,750 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+type atomicRouter struct {
+	BaseRouter
+	calls atomic.Int64
+}
+
+func (r *atomicRouter) Handle(request ziface.IRequest) {
+	r.calls.Add(1)
+}
+
+func TestRuntimeRoutes(t *testing.T) {
+	mh := NewMsgHandle()
+	v1, v2 := &atomicRouter{}, &atomicRouter{}
+	mh.AddRouter(1, v1)
+	conn := &Connection{ctx: context.Background()}
+
+	// 分发消息的同时替换路由, 在 -race 下不应出现数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1, nil)))
+			}
+		}()
+	}
+	if old := mh.ReplaceRouter(1, v2, WithAnonymous()); old != v1 {
+		t.Fatalf("ReplaceRouter returned %v", old)
+	}
+	wg.Wait()
+	if v1.calls.Load()+v2.calls.Load() != 400 {
+		t.Fatalf("calls = %d + %d", v1.calls.Load(), v2.calls.Load())
+	}
+
+	mh.AddRouter(0, &atomicRouter{})
+	routes := mh.Routes()
+	if len(routes) != 2 || routes[0].MsgId != 0 || routes[1].Router != v2 || !routes[1].Anonymous {
+		t.Fatalf("routes = %+v", routes)
+	}
+
+	if err := mh.RemoveRouter(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mh.RemoveRouter(1); !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("remove twice: %v", err)
+	}
+	before := v2.calls.Load()
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1, nil)))
+	if v2.calls.Load() != before {
+		t.Fatal("removed route still dispatched")
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	mh := NewMsgHandle()
+	login := mh.Group(1000, 1999)
+	mh.Group(2000, 2999)
+
+	var trace []string
+	mw := func(name string) ziface.Middleware {
+		return func(next ziface.HandlerFunc) ziface.HandlerFunc {
+			return func(request ziface.IRequest) {
+				trace = append(trace, name)
+				next(request)
+			}
+		}
+	}
+	login.Use(mw("outer"), mw("inner"))
+	route, fallback, other := &atomicRouter{}, &atomicRouter{}, &atomicRouter{}
+	login.AddRouter(1001, route)
+	login.SetDefault(fallback)
+	mh.AddRouter(1, other)
+
+	conn := &Connection{ctx: context.Background()}
+	for _, msgId := range []uint32{1001, 1500, 2500, 1} {
+		mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(msgId, nil)))
+	}
+	if route.calls.Load() != 1 || fallback.calls.Load() != 1 || other.calls.Load() != 1 {
+		t.Fatalf("calls: route = %d, fallback = %d, other = %d", route.calls.Load(), fallback.calls.Load(), other.calls.Load())
+	}
+	if want := []string{"outer", "inner", "outer", "inner"}; !slices.Equal(trace, want) {
+		t.Fatalf("middleware trace = %v", trace)
+	}
+
+	for name, fn := range map[string]func(){
+		"overlap":      func() { mh.Group(1500, 2500) },
+		"out of range": func() { login.AddRouter(3000, route) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestGroupDefaultAuth(t *testing.T) {
+	mh := NewMsgHandle()
+	mh.metrics = NewMetrics()
+	var logins atomic.Int32
+	// 登录消息 ID 即分组起点且没有单独注册, 登录消息与组内其余消息都由默认路由处理
+	mh.SetAuthenticator(1000, func(request ziface.IRequest) (ziface.IIdentity, error) {
+		logins.Add(1)
+		return NewIdentity("player"), nil
+	})
+	fallback := &atomicRouter{}
+	mh.Group(1000, 1999).SetDefault(fallback)
+
+	// 默认路由记录实际处理的 msgId, 而不是分组的起点或 0
+	route, _ := mh.lookupRoute(1500)
+	if !route.IsDefault || route.MsgId != 1500 || route.ReplyMsgId != 1500 {
+		t.Fatalf("default route: IsDefault = %v, MsgId = %d, ReplyMsgId = %d, want true, 1500, 1500",
+			route.IsDefault, route.MsgId, route.ReplyMsgId)
+	}
+	if routes := mh.Routes(); len(routes) != 0 {
+		t.Fatalf("Routes() = %v, want no group default", routes)
+	}
+
+	conn := &Connection{ctx: context.Background()}
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1500, nil)))
+	if fallback.calls.Load() != 0 {
+		t.Fatal("unauthenticated request reached group default")
+	}
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1000, nil)))
+	if logins.Load() != 1 || fallback.calls.Load() != 1 {
+		t.Fatalf("login: authenticator = %d, group default = %d, want 1, 1", logins.Load(), fallback.calls.Load())
+	}
+	mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(1500, nil)))
+	if fallback.calls.Load() != 2 {
+		t.Fatalf("authenticated group default = %d, want 2", fallback.calls.Load())
+	}
+	// 默认路由处理的消息按实际的 msgId 统计
+	if n := mh.metrics.msg(1500).latencyCount.Load(); n != 1 {
+		t.Errorf("msg 1500 handled = %d, want 1", n)
+	}
+	if _, ok := mh.metrics.msgs[0]; ok {
+		t.Error("group default traffic attributed to msgId 0")
+	}
+}
+
+// seqRouter 记录每个连接收到的消息序号
+type seqRouter struct {
+	BaseRouter
+	lock sync.Mutex
+	seqs map[uint32][]int
+	done sync.WaitGroup
+}
+
+func (r *seqRouter) Handle(request ziface.IRequest) {
+	r.lock.Lock()
+	connID := request.GetConnection().GetConnID()
+	r.seqs[connID] = append(r.seqs[connID], int(request.GetData()[0]))
+	r.lock.Unlock()
+	r.done.Done()
+}
+
+func TestOrderedDispatch(t *testing.T) {
+	mode := settings.Conf.DispatchMode
+	settings.Conf.DispatchMode = DispatchOrdered
+	defer func() { settings.Conf.DispatchMode = mode }()
+
+	for _, poolSize := range []uint32{0, 2} {
+		mh := NewMsgHandle()
+		mh.WorkerPoolSize = poolSize
+		mh.StartWorkerPool()
+
+		router := &seqRouter{seqs: make(map[uint32][]int)}
+		mh.AddRouter(1, router)
+		const conns, msgs = 3, 100
+		router.done.Add(conns * msgs)
+		var cs []*Connection
+		for id := uint32(0); id < conns; id++ {
+			cs = append(cs, &Connection{ConnID: id, ctx: context.Background()})
+		}
+		for i := 0; i < msgs; i++ {
+			for _, conn := range cs {
+				mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, []byte{byte(i)})))
+			}
+		}
+		router.done.Wait()
+		// 等待 mailbox 全部处理完毕, 避免 worker 在之后的测试修改配置时仍在读取
+		for _, conn := range cs {
+			for conn.mailbox.scheduled.Load() {
+				runtime.Gosched()
+			}
+		}
+		mh.SetWorkerPoolSize(0)
+
+		for id, seq := range router.seqs {
+			for i, n := range seq {
+				if n != i {
+					t.Fatalf("pool %d: conn %d got %v", poolSize, id, seq[:i+1])
+				}
+			}
+		}
+	}
+}
+
+func TestOrderedRunQueueOverflow(t *testing.T) {
+	mode, maxConn := settings.Conf.DispatchMode, settings.Conf.MaxConn
+	settings.Conf.DispatchMode, settings.Conf.MaxConn = DispatchOrdered, 1
+	defer func() { settings.Conf.DispatchMode, settings.Conf.MaxConn = mode, maxConn }()
+
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 1
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+	blocker := &blockingRouter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	other := &atomicRouter{}
+	mh.AddRouter(1, blocker)
+	mh.AddRouter(2, other)
+
+	blocker.done.Add(1)
+	mh.SendMsgToTaskQueue(NewRequest(&Connection{ConnID: 0, ctx: context.Background()}, NewMsgPackage(1, nil)))
+	<-blocker.started
+
+	// max_conn 热更新调大后连接数超过运行队列的容量, 调度 mailbox 不能阻塞
+	sent := make(chan struct{})
+	go func() {
+		for id := uint32(1); id <= 3; id++ {
+			mh.SendMsgToTaskQueue(NewRequest(&Connection{ConnID: id, ctx: context.Background()}, NewMsgPackage(2, nil)))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(3 * time.Second):
+		t.Fatal("scheduling a mailbox blocked on a full run queue")
+	}
+	if n := mh.runQueueLen(); n != 3 {
+		t.Errorf("run queue len = %d, want 3", n)
+	}
+
+	close(blocker.release)
+	blocker.done.Wait()
+	for other.calls.Load() != 3 {
+		runtime.Gosched()
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 2
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+
+	router := &seqRouter{seqs: make(map[uint32][]int)}
+	mh.AddRouter(1, router)
+	const conns, msgs = 5, 200
+	router.done.Add(conns * msgs)
+	var cs []*Connection
+	for id := uint32(0); id < conns; id++ {
+		cs = append(cs, &Connection{ConnID: id, ctx: context.Background()})
+	}
+
+	// 分发消息的过程中扩容与缩容, 同一连接的消息仍应按顺序处理
+	sizes := map[int]uint32{50: 7, 100: 1, 150: 3}
+	for i := 0; i < msgs; i++ {
+		if size, ok := sizes[i]; ok {
+			mh.SetWorkerPoolSize(size)
+			if got := mh.GetWorkerPoolSize(); got != size {
+				t.Fatalf("pool size = %d, want %d", got, size)
+			}
+		}
+		for _, conn := range cs {
+			mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, []byte{byte(i)})))
+		}
+	}
+	router.done.Wait()
+
+	for id, seq := range router.seqs {
+		if len(seq) != msgs {
+			t.Fatalf("conn %d got %d msgs, want %d", id, len(seq), msgs)
+		}
+		for i, n := range seq {
+			if n != i {
+				t.Fatalf("conn %d got %v", id, seq[:i+1])
+			}
+		}
+	}
+}
+
+// redispatchRouter 阻塞到 release 被关闭, 再在处理链中向同一连接分发 msgId 为 2 的消息
+type redispatchRouter struct {
+	BaseRouter
+	mh      *MsgHandle
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *redispatchRouter) Handle(request ziface.IRequest) {
+	close(r.started)
+	<-r.release
+	r.mh.SendMsgToTaskQueue(NewRequest(request.GetConnection(), NewMsgPackage(2, nil)))
+}
+
+func TestResizeDuringSlowHandler(t *testing.T) {
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 2
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+
+	slow := &redispatchRouter{mh: mh, started: make(chan struct{}), release: make(chan struct{})}
+	next := &atomicRouter{}
+	mh.AddRouter(1, slow)
+	mh.AddRouter(2, next)
+
+	mh.SendMsgToTaskQueue(NewRequest(&Connection{ConnID: 0, ctx: context.Background()}, NewMsgPackage(1, nil)))
+	<-slow.started
+	resized := make(chan struct{})
+	go func() {
+		mh.SetWorkerPoolSize(3)
+		close(resized)
+	}()
+	for mh.GetWorkerPoolSize() != 3 {
+		runtime.Gosched()
+	}
+
+	// 旧 worker 仍在处理慢消息时, 其他连接的分发不被调整阻塞
+	sent := make(chan struct{})
+	go func() {
+		mh.SendMsgToTaskQueue(NewRequest(&Connection{ConnID: 1, ctx: context.Background()}, NewMsgPackage(2, nil)))
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(3 * time.Second):
+		t.Fatal("dispatch of another conn blocked by resize")
+	}
+	select {
+	case <-resized:
+		t.Fatal("resize returned before the retiring worker finished")
+	default:
+	}
+
+	// 慢消息在处理链中再次分发, 调整不会因此死锁
+	close(slow.release)
+	select {
+	case <-resized:
+	case <-time.After(3 * time.Second):
+		t.Fatal("resize deadlocked with a handler dispatching during retirement")
+	}
+	for next.calls.Load() != 2 {
+		runtime.Gosched()
+	}
+}
+
+// blockingRouter 阻塞处理消息直到 release 被关闭, started 不为 nil 时在开始处理时非阻塞地通知
+type blockingRouter struct {
+	BaseRouter
+	started chan struct{}
+	release chan struct{}
+	done    sync.WaitGroup
+}
+
+func (r *blockingRouter) Handle(request ziface.IRequest) {
+	if r.started != nil {
+		select {
+		case r.started <- struct{}{}:
+		default:
+		}
+	}
+	<-r.release
+	r.done.Done()
+}
+
+func TestWorkStealing(t *testing.T) {
+	taskLen := settings.Conf.MaxWorkerTaskLen
+	settings.Conf.MaxWorkerTaskLen = 1
+	defer func() { settings.Conf.MaxWorkerTaskLen = taskLen }()
+
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 4
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+
+	router := &blockingRouter{release: make(chan struct{})}
+	mh.AddRouter(1, router, WithUnordered())
+	const msgs = 4
+	router.done.Add(msgs)
+
+	// 全部消息来自同一个连接, 都会分配给 worker 0. worker 0 阻塞在第一条消息上,
+	// 其余消息只有被空闲的 worker 窃取后才能放入队列
+	conn := &Connection{ConnID: 0, ctx: context.Background()}
+	for i := 0; i < msgs; i++ {
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+	}
+	close(router.release)
+	router.done.Wait()
+
+	var stolen uint64
+	for _, w := range *mh.workerList.Load() {
+		stolen += w.stats.stolen.Load()
+	}
+	if stolen == 0 {
+		t.Fatal("no request was stolen")
+	}
+}
+
+// orderRouter 按处理顺序记录消息的 msgId 与序号
+type orderRouter struct {
+	BaseRouter
+	lock  sync.Mutex
+	order []string
+	done  sync.WaitGroup
+}
+
+func (r *orderRouter) Handle(request ziface.IRequest) {
+	r.lock.Lock()
+	r.order = append(r.order, fmt.Sprintf("%d:%d", request.GetMsgID(), request.GetData()[0]))
+	r.lock.Unlock()
+	r.done.Done()
+}
+
+func TestPriorityLanes(t *testing.T) {
+	taskLen, limit := settings.Conf.MaxWorkerTaskLen, settings.Conf.StarvationLimit
+	settings.Conf.MaxWorkerTaskLen, settings.Conf.StarvationLimit = 16, 3
+	defer func() { settings.Conf.MaxWorkerTaskLen, settings.Conf.StarvationLimit = taskLen, limit }()
+
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 1
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+
+	blocker := &blockingRouter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	blocker.done.Add(1)
+	router := &orderRouter{}
+	mh.AddRouter(1, blocker)
+	mh.AddRouter(2, router, WithPriority(ziface.PriorityHigh))
+	mh.AddRouter(3, router, WithPriority(ziface.PriorityLow))
+
+	// 先用一条消息占住唯一的 worker, 再交替放入高低优先级的消息
+	conn := &Connection{ConnID: 0, ctx: context.Background()}
+	mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+	<-blocker.started
+	const msgs = 6
+	router.done.Add(2 * msgs)
+	for i := 0; i < msgs; i++ {
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(3, []byte{byte(i)})))
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(2, []byte{byte(i)})))
+	}
+	close(blocker.release)
+	router.done.Wait()
+
+	// 每连续处理 3 条高优先级消息后, 先处理一条低优先级消息; 同一优先级内保持顺序
+	want := []string{"2:0", "2:1", "2:2", "3:0", "2:3", "2:4", "2:5", "3:1", "3:2", "3:3", "3:4", "3:5"}
+	if !slices.Equal(router.order, want) {
+		t.Fatalf("order = %v, want %v", router.order, want)
+	}
+	// 第二次先处理低优先级消息时高优先级通道已空, 不计入饿死保护
+	if starved := (*mh.workerList.Load())[0].stats.starved.Load(); starved != 1 {
+		t.Errorf("starved = %d, want 1", starved)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	taskLen, policy, busyMsgId := settings.Conf.MaxWorkerTaskLen, settings.Conf.Backpressure.Policy, settings.Conf.Backpressure.BusyMsgId
+	settings.Conf.MaxWorkerTaskLen, settings.Conf.Backpressure.BusyMsgId = 1, 99
+	defer func() {
+		settings.Conf.MaxWorkerTaskLen, settings.Conf.Backpressure.Policy = taskLen, policy
+		settings.Conf.Backpressure.BusyMsgId = busyMsgId
+	}()
+
+	for _, policy := range []string{BackpressureDrop, BackpressureBusy, BackpressurePause} {
+		settings.Conf.Backpressure.Policy = policy
+		mh := NewMsgHandle()
+		mh.metrics = NewMetrics()
+		mh.WorkerPoolSize = 1
+		mh.StartWorkerPool()
+
+		var full atomic.Int64
+		mh.SetOnQueueFull(func(conn ziface.IConnection, msgId uint32) { full.Add(1) })
+		blocker := &blockingRouter{started: make(chan struct{}, 1), release: make(chan struct{})}
+		mh.AddRouter(1, blocker)
+
+		// worker 阻塞在第一条消息上, 第二条消息占满容量为 1 的队列, 第三条消息触发背压
+		conn := &Connection{ConnID: 0, ctx: context.Background(), msgBuffChan: make(chan *[]byte, 1)}
+		blocker.done.Add(1)
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+		<-blocker.started
+		blocker.done.Add(1)
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+
+		if policy == BackpressurePause {
+			blocker.done.Add(1)
+			sent := make(chan struct{})
+			go func() {
+				mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+				close(sent)
+			}()
+			for full.Load() == 0 {
+				runtime.Gosched()
+			}
+			select {
+			case <-sent:
+				t.Fatal("pause: SendMsgToTaskQueue returned while the queue is full")
+			default:
+			}
+			close(blocker.release)
+			<-sent
+		} else {
+			mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+			if full.Load() != 1 || mh.metrics.taskDrops.Load() != 1 {
+				t.Fatalf("%s: full = %d, dropped = %d, want 1, 1", policy, full.Load(), mh.metrics.taskDrops.Load())
+			}
+			close(blocker.release)
+		}
+		blocker.done.Wait()
+
+		if policy == BackpressureBusy && len(conn.msgBuffChan) != 1 {
+			t.Errorf("busy: no reply queued")
+		}
+		if policy == BackpressurePause && mh.metrics.taskDrops.Load() != 0 {
+			t.Errorf("pause: dropped = %d, want 0", mh.metrics.taskDrops.Load())
+		}
+		mh.SetWorkerPoolSize(0)
+	}
+}
+
+func TestPauseReleasesPoolLock(t *testing.T) {
+	mode, taskLen, policy := settings.Conf.DispatchMode, settings.Conf.MaxWorkerTaskLen, settings.Conf.Backpressure.Policy
+	settings.Conf.MaxWorkerTaskLen, settings.Conf.Backpressure.Policy = 1, BackpressurePause
+	defer func() {
+		settings.Conf.DispatchMode, settings.Conf.MaxWorkerTaskLen, settings.Conf.Backpressure.Policy = mode, taskLen, policy
+	}()
+
+	for _, mode := range []string{DispatchHash, DispatchOrdered} {
+		settings.Conf.DispatchMode = mode
+		mh := NewMsgHandle()
+		mh.WorkerPoolSize = 2
+		mh.StartWorkerPool()
+
+		var full atomic.Int64
+		mh.SetOnQueueFull(func(conn ziface.IConnection, msgId uint32) { full.Add(1) })
+		blocker := &blockingRouter{started: make(chan struct{}, 1), release: make(chan struct{})}
+		other := &atomicRouter{}
+		mh.AddRouter(1, blocker)
+		mh.AddRouter(2, other)
+
+		// 连接 0 的 worker 阻塞在第一条消息上, 第二条消息占满队列, 第三条消息在 pause 策略下等待
+		conn := &Connection{ConnID: 0, ctx: context.Background()}
+		blocker.done.Add(3)
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+		<-blocker.started
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+		go mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+		for full.Load() == 0 {
+			runtime.Gosched()
+		}
+
+		// 等待期间不持有 poolLock, 调整工作池大小与其他连接的分发都不会被阻塞
+		if !mh.poolLock.TryLock() {
+			t.Fatalf("%s: poolLock held while waiting for queue space", mode)
+		}
+		mh.poolLock.Unlock()
+		mh.SendMsgToTaskQueue(NewRequest(&Connection{ConnID: 1, ctx: context.Background()}, NewMsgPackage(2, nil)))
+		for other.calls.Load() == 0 {
+			runtime.Gosched()
+		}
+
+		close(blocker.release)
+		blocker.done.Wait()
+		mh.SetWorkerPoolSize(0)
+	}
+}
+
+// hungRouter 等待请求的 context 被取消后记录取消原因, 再阻塞到 release 被关闭
+type hungRouter struct {
+	BaseRouter
+	err     chan error
+	release chan struct{}
+}
+
+func (r *hungRouter) Handle(request ziface.IRequest) {
+	<-request.Context().Done()
+	r.err <- request.Context().Err()
+	<-r.release
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	timeoutMsgId, threshold := settings.Conf.TimeoutMsgId, settings.Conf.SlowHandlerThreshold
+	settings.Conf.TimeoutMsgId, settings.Conf.SlowHandlerThreshold = 99, time.Millisecond
+	defer func() { settings.Conf.TimeoutMsgId, settings.Conf.SlowHandlerThreshold = timeoutMsgId, threshold }()
+
+	mh := NewMsgHandle()
+	mh.metrics = NewMetrics()
+	tracer := NewMemoryTracer()
+	mh.SetTracer(tracer)
+	mh.WorkerPoolSize = 1
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+
+	timeouts := make(chan uint32, 1)
+	mh.SetOnTimeout(func(conn ziface.IConnection, msgId uint32, timeout time.Duration) { timeouts <- msgId })
+	hung := &hungRouter{err: make(chan error, 1), release: make(chan struct{})}
+	next := &atomicRouter{}
+	mh.AddRouter(1, hung, WithTimeout(20*time.Millisecond))
+	mh.AddRouter(2, next)
+
+	conn := &Connection{ConnID: 0, ctx: context.Background(), msgBuffChan: make(chan *[]byte, 1)}
+	mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+	mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(2, nil)))
+
+	if err := <-hung.err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("handler ctx err = %v, want DeadlineExceeded", err)
+	}
+	if msgId := <-timeouts; msgId != 1 {
+		t.Fatalf("timeout hook msgId = %d, want 1", msgId)
+	}
+	// 超时的处理链仍阻塞着, worker 应已继续处理后续消息
+	for next.calls.Load() == 0 {
+		runtime.Gosched()
+	}
+	if len(conn.msgBuffChan) != 1 {
+		t.Error("no timeout reply queued")
+	}
+	if n := mh.metrics.timeouts.Load(); n != 1 {
+		t.Errorf("timeouts = %d, want 1", n)
+	}
+	if span := requestSpan(tracer, 1); span != nil {
+		t.Error("request span ended while the timed-out handler is still running")
+	}
+
+	// 超时的处理链结束时耗时超过 slow_handler_threshold, 记录为慢处理
+	close(hung.release)
+	for mh.metrics.slowHandlers.Load() == 0 {
+		runtime.Gosched()
+	}
+	// 请求 span 由超时的处理链结束, 覆盖其全部阶段
+	var span *MemorySpan
+	for span = requestSpan(tracer, 1); span == nil; span = requestSpan(tracer, 1) {
+		runtime.Gosched()
+	}
+	if !errors.Is(span.Err, ErrHandlerTimeout) {
+		t.Errorf("request span err = %v, want ErrHandlerTimeout", span.Err)
+	}
+	for _, phase := range tracer.Spans() {
+		if phase.Parent == span.Context && phase.EndTime.After(span.EndTime) {
+			t.Errorf("phase %s ended after the request span", phase.Name)
+		}
+	}
+}
+
+// requestSpan 返回 msgId 已经结束的请求 span, 不存在时返回 nil
+func requestSpan(tracer *MemoryTracer, msgId uint32) *MemorySpan {
+	for _, span := range tracer.Spans() {
+		if span.Name == "zinx.request" && span.Attributes["zinx.msg_id"] == msgId {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestOrderedRejectsTimeout(t *testing.T) {
+	mode := settings.Conf.DispatchMode
+	settings.Conf.DispatchMode = DispatchOrdered
+	defer func() { settings.Conf.DispatchMode = mode }()
+
+	// 超时的处理链会与后续消息并发执行, ordered 模式下注册时 panic 且不修改路由表
+	mh := NewMsgHandle()
+	for name, fn := range map[string]func(){
+		"add":     func() { mh.AddRouter(1, &atomicRouter{}, WithTimeout(time.Second)) },
+		"replace": func() { mh.ReplaceRouter(1, &atomicRouter{}, WithTimeout(time.Second)) },
+		"default": func() { mh.Group(1000, 1999).SetDefault(&atomicRouter{}, WithTimeout(time.Second)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+	if routes := mh.Routes(); len(routes) != 0 {
+		t.Errorf("routes = %v, want none", routes)
+	}
+	mh.AddRouter(1, &atomicRouter{})
+}
+
+func TestTickMode(t *testing.T) {
+	mode, interval := settings.Conf.DispatchMode, settings.Conf.Tick.Interval
+	settings.Conf.DispatchMode, settings.Conf.Tick.Interval = DispatchTick, 5*time.Millisecond
+	defer func() { settings.Conf.DispatchMode, settings.Conf.Tick.Interval = mode, interval }()
+
+	mh := NewMsgHandle()
+	mh.metrics = NewMetrics()
+	type batch struct {
+		tick   uint64
+		msgIds []uint32
+	}
+	batches := make(chan batch, 16)
+	var overran atomic.Bool
+	mh.SetOnTick(func(tick uint64, requests []ziface.IRequest) {
+		b := batch{tick: tick}
+		for _, request := range requests {
+			b.msgIds = append(b.msgIds, request.GetMsgID())
+		}
+		// 第一个有消息的 tick 之后制造一次超时
+		if len(requests) > 0 && overran.CompareAndSwap(false, true) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		select {
+		case batches <- b:
+		default:
+		}
+	})
+
+	// tick 开始前到达的消息全部进入第一个 tick, 保持到达顺序
+	conn := &Connection{ConnID: 0, ctx: context.Background()}
+	for _, msgId := range []uint32{3, 1, 2} {
+		mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(msgId, nil)))
+	}
+	mh.StartWorkerPool()
+	defer mh.stopTick()
+
+	first := <-batches
+	if first.tick != 1 || !slices.Equal(first.msgIds, []uint32{3, 1, 2}) {
+		t.Fatalf("first tick = %d %v, want 1 [3 1 2]", first.tick, first.msgIds)
+	}
+	// 超时的 tick 之后跳过落后的 tick, 序号仍按逻辑时间递增
+	if next := <-batches; next.tick <= first.tick+1 || len(next.msgIds) != 0 {
+		t.Errorf("tick after overrun = %d %v, want > %d and no input", next.tick, next.msgIds, first.tick+1)
+	}
+	if n := mh.metrics.tickOverruns.Load(); n != 1 {
+		t.Errorf("tick overruns = %d, want 1", n)
+	}
+	if mh.metrics.tickSkipped.Load() == 0 {
+		t.Error("no tick skipped after overrun")
+	}
+}
@@ -0,0 +1,48 @@
+package znet
+
+import (
+	"zinx/settings"
+)
+
+// MsgIdNegotiate 为保留的特性协商消息 ID.
+// 客户端可以在连接建立后的第一条消息中发送协商请求, 数据为 1 字节的特性位图;
+// 服务端以同一 msgId 回复双方都支持的特性位图, 此后双方按协商结果收发消息.
+// 不发送协商请求的客户端不会启用任何特性, 与旧版本协议完全一致.
+const MsgIdNegotiate uint32 = 0xFFFFFFFF
+
+// 可协商的特性
+const (
+	FeatureCompression  byte = 1 << 0 // 超过 compress_threshold 的消息使用 flate 压缩
+	FeatureTraceContext byte = 1 << 1 // 客户端的消息可以携带追踪上下文
+)
+
+// serverFeatures 根据配置得到服务端支持的特性
+func serverFeatures() byte {
+	features := FeatureTraceContext
+	if settings.Conf.Compression {
+		features |= FeatureCompression
+	}
+	return features
+}
+
+// negotiate 处理客户端的协商请求, 回复协商结果后启用对应的特性
+func (c *Connection) negotiate(data []byte) error {
+	var requested byte
+	if len(data) > 0 {
+		requested = data[0]
+	}
+	accepted := requested & serverFeatures()
+
+	// 协商回复本身总是不压缩的, 因此在回复交给写 goroutine 之后再启用压缩
+	if err := c.SendMsg(MsgIdNegotiate, []byte{accepted}); err != nil {
+		return err
+	}
+	if accepted&FeatureCompression != 0 {
+		c.compression.Store(true)
+	}
+	if accepted&FeatureTraceContext != 0 {
+		c.traceContext.Store(true)
+	}
+	c.logger().Debug("features negotiated", "connID", c.ConnID, "remote", addrString(c.RemoteAddr()), "features", accepted)
+	return nil
+}
@@ -0,0 +1,124 @@
+package znet
+
+import (
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// 优先级通道, 下标越小优先级越高
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+	laneCount
+)
+
+// laneNames 为各优先级通道在指标与管理后台中的名称
+var laneNames = [laneCount]string{"high", "normal", "low"}
+
+// defaultStarvationLimit 为未配置 starvation_limit 时连续优先处理高优先级消息的最大次数
+const defaultStarvationLimit = 32
+
+// WithPriority 指定消息的优先级. 不同优先级的消息进入 worker 不同的通道, worker 总是先处理高优先级通道,
+// 因此同一连接内只保证相同优先级的消息按顺序处理
+func WithPriority(priority ziface.Priority) ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.Priority = priority
+	}
+}
+
+// laneOf 返回优先级对应的通道
+func laneOf(priority ziface.Priority) int {
+	switch {
+	case priority > ziface.PriorityNormal:
+		return laneHigh
+	case priority < ziface.PriorityNormal:
+		return laneLow
+	default:
+		return laneNormal
+	}
+}
+
+// lanes 为按优先级划分的一组任务队列
+type lanes [laneCount]chan ziface.IRequest
+
+// newLanes 创建每个通道容量为 size 的任务队列
+func newLanes(size uint32) (l lanes) {
+	for i := range l {
+		l[i] = make(chan ziface.IRequest, size)
+	}
+	return l
+}
+
+// len 返回全部通道中等待处理的消息数
+func (l *lanes) len() (n int) {
+	for _, ch := range l {
+		n += len(ch)
+	}
+	return n
+}
+
+// starvationLimit 返回连续优先处理高优先级消息的最大次数
+func starvationLimit() int {
+	if limit := settings.Conf.StarvationLimit; limit > 0 {
+		return limit
+	}
+	return defaultStarvationLimit
+}
+
+// popLanes 非阻塞地从 queues 中取出下一条消息, 没有消息时返回 nil. 通常先取最高优先级的非空通道;
+// 若已连续 starvationLimit 次在低优先级通道非空时优先处理了高优先级的消息, 则先取最低优先级的非空通道,
+// 此时若越过了非空的高优先级通道, starved 为 true. skipped 记录连续跳过的次数, 只能由同一时刻唯一的消费者读写
+func popLanes(skipped *int, queues ...*lanes) (request ziface.IRequest, starved bool) {
+	starving := *skipped >= starvationLimit()
+	for i := 0; i < laneCount; i++ {
+		lane := i
+		if starving {
+			lane = laneCount - 1 - i
+		}
+		for _, q := range queues {
+			select {
+			case request = <-q[lane]:
+			default:
+				continue
+			}
+
+			if starving {
+				*skipped = 0
+				return request, pending(queues, 0, lane)
+			}
+			if pending(queues, lane+1, laneCount) {
+				*skipped++
+			} else {
+				*skipped = 0
+			}
+			return request, false
+		}
+	}
+	return nil, false
+}
+
+// pending 判断 queues 的 [from, to) 通道中是否有消息在等待
+func pending(queues []*lanes, from, to int) bool {
+	for _, q := range queues {
+		for l := from; l < to; l++ {
+			if len(q[l]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeClass 返回消息进入的优先级通道, 以及是否可以被其他 worker 窃取. 未注册的 msgId 按所在分组的默认路由分类.
+// 登录消息固定进入最高优先级的有序通道, 否则其后需要认证的消息可能先于它被处理而被拒绝
+func (mh *MsgHandle) routeClass(msgId uint32) (lane int, unordered bool) {
+	if mh.authenticator != nil && msgId == mh.loginMsgId {
+		return laneHigh, false
+	}
+	route, _ := mh.lookupRoute(msgId)
+	if route == nil {
+		return laneNormal, false
+	}
+	return laneOf(route.Priority), route.Unordered
+}
@@ -0,0 +1,165 @@
+package znet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"zinx/settings"
+)
+
+/*
+	PROXY protocol v1/v2 解析, 用于 zinx 部署在 L4 负载均衡之后时取得真实的客户端地址.
+	只解析来自 proxy_protocol.trusted_proxies 的连接; 读取头部时逐字节或按长度精确读取,
+	不会多读任何属于后续帧的数据.
+*/
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyV1MaxLen 为 v1 头部的最大长度, 包括结尾的 \r\n
+const proxyV1MaxLen = 107
+
+// readProxyHeader 从 r 读取一个 PROXY protocol v1 或 v2 头部, 返回其中的源地址.
+// 头部声明 LOCAL 或 UNKNOWN 时返回 nil, 调用方应继续使用连接本身的地址.
+func readProxyHeader(r io.Reader) (net.Addr, error) {
+	// v1 头部最短为 "PROXY UNKNOWN\r\n" 共 15 字节, 因此先读 12 字节不会越界
+	sig := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(sig, proxyV1Prefix):
+		return readProxyV1(r, sig)
+	default:
+		return nil, errors.New("proxy protocol: missing header")
+	}
+}
+
+// readProxyV1 解析形如 "PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\n" 的文本头部
+func readProxyV1(r io.Reader, prefix []byte) (net.Addr, error) {
+	line := append(make([]byte, 0, proxyV1MaxLen), prefix...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, errors.New("proxy protocol: v1 header too long")
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 {
+		return nil, errors.New("proxy protocol: bad v1 header")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errors.New("proxy protocol: unsupported v1 protocol " + fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("proxy protocol: bad v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errors.New("proxy protocol: bad v1 source address")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 解析二进制头部, 签名之后为 ver_cmd(1) fam(1) len(2) 以及地址信息
+func readProxyV2(r io.Reader) (net.Addr, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 2 {
+		return nil, errors.New("proxy protocol: unsupported v2 version")
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(head[2:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch head[0] & 0xF {
+	case 0x0: // LOCAL, 如负载均衡的健康检查
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errors.New("proxy protocol: unsupported v2 command")
+	}
+
+	switch head[1] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("proxy protocol: short v2 ipv4 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("proxy protocol: short v2 ipv6 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// resolveProxy 处理开启了 PROXY protocol 时新接受的连接, 返回真实的客户端地址与代理地址;
+// 代理地址为 nil 表示客户端直连
+func (s *Server) resolveProxy(conn *net.TCPConn) (remote net.Addr, proxy net.Addr, err error) {
+	conf := settings.Conf.ProxyProtocol
+	peer := conn.RemoteAddr()
+
+	if !ipInNets(peer.(*net.TCPAddr).IP, *s.trustedProxies.Load()) {
+		// 非受信来源的 PROXY 头一律不解析, 伪造的头部会在拆包时被当作非法数据拒绝
+		if !conf.AllowDirect {
+			return nil, nil, errors.New("proxy protocol: untrusted proxy " + peer.String())
+		}
+		return peer, nil, nil
+	}
+
+	timeout := conf.HeaderTimeout
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	src, err := readProxyHeader(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if src == nil {
+		return peer, nil, nil
+	}
+	return src, peer, nil
+}
+
+// ipInNets 判断 ip 是否属于 nets 中的任一网段
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
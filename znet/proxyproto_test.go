@@ -0,0 +1,56 @@
+package znet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	r := bytes.NewReader([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 7777\r\nFRAME"))
+	addr, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "203.0.113.7:51234" {
+		t.Fatalf("addr = %s", addr)
+	}
+	// 头部之后的数据不能被多读
+	if rest, _ := io.ReadAll(r); string(rest) != "FRAME" {
+		t.Fatalf("rest = %q", rest)
+	}
+
+	if addr, err := readProxyHeader(bytes.NewReader([]byte("PROXY UNKNOWN\r\n"))); err != nil || addr != nil {
+		t.Fatalf("UNKNOWN: addr = %v, err = %v", addr, err)
+	}
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12) // v2 PROXY, TCP over IPv4, 12 字节地址
+	header = append(header, net.IPv4(198, 51, 100, 9).To4()...)
+	header = append(header, net.IPv4(10, 0, 0, 1).To4()...)
+	header = binary.BigEndian.AppendUint16(header, 40000)
+	header = binary.BigEndian.AppendUint16(header, 7777)
+
+	r := bytes.NewReader(append(header, "FRAME"...))
+	addr, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "198.51.100.9:40000" {
+		t.Fatalf("addr = %s", addr)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "FRAME" {
+		t.Fatalf("rest = %q", rest)
+	}
+}
+
+func TestReadProxyHeaderMissing(t *testing.T) {
+	frame, _ := NewDataPack().Pack(NewMsgPackage(1, []byte("hello zinx")))
+	if _, err := readProxyHeader(bytes.NewReader(frame)); err == nil {
+		t.Fatal("frame without proxy header accepted")
+	}
+}
@@ -0,0 +1,242 @@
+package znet
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+	"zinx/settings"
+)
+
+// 超出速率限制时的处理策略
+const (
+	RateLimitDrop       = "drop"       // 丢弃该消息
+	RateLimitDelay      = "delay"      // 暂停读取, 等到令牌足够后再处理, 依靠 TCP 流控使客户端减速
+	RateLimitThrottle   = "throttle"   // 丢弃该消息, 并回复 throttle_msg_id, 数据为被丢弃消息的 msgId
+	RateLimitDisconnect = "disconnect" // 断开连接
+)
+
+// tokenBucket 为令牌桶, 以 rate 个/秒的速度补充令牌, 最多积攒 burst 个
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建令牌桶, rate <= 0 表示不限制, 返回 nil
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill 按流逝的时间补充令牌, 调用方须持有锁
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow 若有令牌则取走一个并返回 true
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund 归还一个令牌, 用于多个令牌桶中后面的桶拒绝时撤销前面的扣减
+func (b *tokenBucket) refund() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens = min(b.tokens+1, b.burst)
+}
+
+// reserve 无论令牌是否足够都取走一个, 返回需要等待多久才算真正拿到令牌
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimiter 为 Server 级别的入站消息限速器, 持有全局与按 IP 共享的令牌桶
+type RateLimiter struct {
+	global *tokenBucket
+
+	ipLock sync.Mutex
+	ips    map[string]*ipBucket // 每个远程 IP 的令牌桶, 该 IP 的最后一个连接停止时删除
+}
+
+type ipBucket struct {
+	bucket *tokenBucket
+	refs   int // 使用该令牌桶的连接数
+}
+
+// NewRateLimiter 根据 settings.Conf.RateLimit 创建限速器, 未配置任何限制时返回 nil
+func NewRateLimiter() *RateLimiter {
+	conf := settings.Conf.RateLimit
+	if conf.GlobalRate <= 0 && conf.IPRate <= 0 && conf.ConnRate <= 0 && len(conf.MsgRates) == 0 {
+		return nil
+	}
+	return &RateLimiter{
+		global: newTokenBucket(conf.GlobalRate, conf.GlobalBurst),
+		ips:    make(map[string]*ipBucket),
+	}
+}
+
+// connLimiter 为单个连接的限速状态
+type connLimiter struct {
+	rl   *RateLimiter
+	ip   string
+	ipb  *tokenBucket
+	conn *tokenBucket
+	msgs map[uint32]*tokenBucket
+}
+
+// acquire 为来自 addr 的新连接创建限速状态, 连接停止时须调用 release
+func (rl *RateLimiter) acquire(addr net.Addr) *connLimiter {
+	conf := settings.Conf.RateLimit
+	cl := &connLimiter{
+		rl:   rl,
+		conn: newTokenBucket(conf.ConnRate, conf.ConnBurst),
+		msgs: make(map[uint32]*tokenBucket, len(conf.MsgRates)),
+	}
+	for _, mr := range conf.MsgRates {
+		if b := newTokenBucket(mr.Rate, mr.Burst); b != nil {
+			cl.msgs[mr.MsgId] = b
+		}
+	}
+
+	if conf.IPRate > 0 {
+		cl.ip = remoteIP(addr)
+		rl.ipLock.Lock()
+		ib, ok := rl.ips[cl.ip]
+		if !ok {
+			ib = &ipBucket{bucket: newTokenBucket(conf.IPRate, conf.IPBurst)}
+			rl.ips[cl.ip] = ib
+		}
+		ib.refs++
+		cl.ipb = ib.bucket
+		rl.ipLock.Unlock()
+	}
+	return cl
+}
+
+// release 释放连接的限速状态, 该 IP 的最后一个连接停止时清理其令牌桶
+func (cl *connLimiter) release() {
+	if cl.ipb == nil {
+		return
+	}
+	cl.rl.ipLock.Lock()
+	defer cl.rl.ipLock.Unlock()
+
+	if ib, ok := cl.rl.ips[cl.ip]; ok {
+		ib.refs--
+		if ib.refs <= 0 {
+			delete(cl.rl.ips, cl.ip)
+		}
+	}
+	cl.ipb = nil
+}
+
+// buckets 返回对 msgId 生效的令牌桶, 顺序为 全局, IP, 连接, msgId
+func (cl *connLimiter) buckets(msgId uint32) [4]*tokenBucket {
+	return [4]*tokenBucket{cl.rl.global, cl.ipb, cl.conn, cl.msgs[msgId]}
+}
+
+// allow 所有令牌桶都有令牌时取走并返回 true, 否则不扣减任何令牌
+func (cl *connLimiter) allow(msgId uint32, now time.Time) bool {
+	buckets := cl.buckets(msgId)
+	for i, b := range buckets {
+		if b == nil || b.allow(now) {
+			continue
+		}
+		for _, taken := range buckets[:i] {
+			if taken != nil {
+				taken.refund()
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// reserve 从所有令牌桶预约令牌, 返回需要等待的最长时间
+func (cl *connLimiter) reserve(msgId uint32, now time.Time) time.Duration {
+	var wait time.Duration
+	for _, b := range cl.buckets(msgId) {
+		if b != nil {
+			wait = max(wait, b.reserve(now))
+		}
+	}
+	return wait
+}
+
+// admitRequest 按照限速策略决定是否将请求交给 MsgHandle 处理
+func (c *Connection) admitRequest(req *Request) bool {
+	msgId := req.GetMsgID()
+	now := time.Now()
+
+	if settings.Conf.RateLimit.Policy == RateLimitDelay {
+		wait := c.limiter.reserve(msgId, now)
+		if wait <= 0 {
+			return true
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-c.ctx.Done():
+			return false
+		}
+	}
+
+	if c.limiter.allow(msgId, now) {
+		return true
+	}
+
+	switch settings.Conf.RateLimit.Policy {
+	case RateLimitThrottle:
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, msgId)
+		_ = c.TrySendBuffMsg(settings.Conf.RateLimit.ThrottleMsgId, data)
+	case RateLimitDisconnect:
+		c.logger().Warn("rate limit exceeded, disconnect", "connID", c.ConnID,
+			"remote", addrString(c.RemoteAddr()), "msgID", msgId)
+		c.Stop()
+	}
+	return false
+}
+
+// remoteIP 取出地址中的 IP 部分
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
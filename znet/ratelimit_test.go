@@ -0,0 +1,50 @@
+package znet
+
+import (
+	"net"
+	"testing"
+	"time"
+	"zinx/settings"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	now := b.last
+	if !b.allow(now) || !b.allow(now) || b.allow(now) {
+		t.Fatal("burst of 2 not enforced")
+	}
+	// 100ms 补充 1 个令牌
+	if !b.allow(now.Add(100 * time.Millisecond)) {
+		t.Fatal("token not refilled")
+	}
+	if wait := b.reserve(now.Add(100 * time.Millisecond)); wait != 100*time.Millisecond {
+		t.Fatalf("reserve wait = %v", wait)
+	}
+}
+
+func TestRateLimiterPerIP(t *testing.T) {
+	settings.Conf.RateLimit = settings.RateLimitConfig{
+		IPRate:  1,
+		IPBurst: 1,
+	}
+	defer func() { settings.Conf.RateLimit = settings.RateLimitConfig{} }()
+
+	rl := NewRateLimiter()
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	a, b := rl.acquire(addr), rl.acquire(addr)
+
+	// 同一 IP 的两个连接共享令牌桶
+	now := time.Now()
+	if !a.allow(1, now) || b.allow(1, now) {
+		t.Fatal("ip bucket not shared")
+	}
+
+	a.release()
+	if len(rl.ips) != 1 {
+		t.Fatal("ip bucket released while still in use")
+	}
+	b.release()
+	if len(rl.ips) != 0 {
+		t.Fatal("ip bucket not cleaned up")
+	}
+}
@@ -1,14 +1,76 @@
 package znet
 
-import "zinx/ziface"
+import (
+	"context"
+	"sync"
+	"zinx/ziface"
+)
 
 type Request struct {
 	conn ziface.IConnection // 已经和客户端建立好的连接
 	msg  ziface.IMessage    // 客户端请求的数据
+	ctx  context.Context    // 请求的 context, 初始为连接的 context, 可以被 SetContext 替换
+
+	message Message // 池化的请求直接使用内嵌的 Message, 避免额外分配
+	buf     *[]byte // 存放消息数据的池化缓冲
+	pooled  bool    // 是否来自 requestPool, 处理链结束后需要归还
+
+	traceParent ziface.SpanContext // 客户端随消息帧带来的追踪上下文
+	span        ziface.ISpan       // 请求的 span, 未设置 ITracer 时为 nil
+	queueSpan   ziface.ISpan       // 记录在任务队列中等待的 span
 }
 
 var _ ziface.IRequest = (*Request)(nil)
 
+// requestPool 复用由 StartReader 创建的 Request
+var requestPool = sync.Pool{
+	New: func() interface{} { return new(Request) },
+}
+
+// NewRequest 创建一个请求, 请求的 context 即为连接的 context, 连接断开时取消.
+// 请求不单独创建可取消的 context, 避免每条消息的分配; 需要请求级别的取消或 deadline 时,
+// 由中间件或 PreHandle 通过 SetContext 设置派生的 context (WithTimeout 的路由即如此)
+func NewRequest(conn ziface.IConnection, msg ziface.IMessage) *Request {
+	return &Request{
+		conn: conn,
+		msg:  msg,
+		ctx:  conn.Context(),
+	}
+}
+
+// getRequest 从 requestPool 中取出一个请求, 其消息数据存放在 buf 中;
+// 处理链执行完毕后由 MsgHandle 调用 release 归还, 因此 Handler 不能在返回后继续持有请求或其数据
+func getRequest(conn ziface.IConnection) *Request {
+	r := requestPool.Get().(*Request)
+	r.conn = conn
+	r.msg = &r.message
+	r.ctx = conn.Context()
+	r.pooled = true
+	return r
+}
+
+// release 归还池化的请求及其数据缓冲, 对非池化的请求不做任何事
+func (r *Request) release() {
+	if !r.pooled {
+		return
+	}
+	if r.buf != nil {
+		putBuffer(r.buf)
+	}
+	*r = Request{}
+	requestPool.Put(r)
+}
+
+// releaseRequest 在处理链结束或消息被丢弃后归还请求; 对定时任务的请求, 标记任务的本次执行已经结束
+func releaseRequest(request ziface.IRequest) {
+	switch r := request.(type) {
+	case *Request:
+		r.release()
+	case *taskRequest:
+		r.task.running.Store(false)
+	}
+}
+
 // GetConnection 获取请求连接信息
 func (r *Request) GetConnection() ziface.IConnection {
 	return r.conn
@@ -23,3 +85,17 @@ func (r *Request) GetData() []byte {
 func (r *Request) GetMsgID() uint32 {
 	return r.msg.GetMsgId()
 }
+
+// Context 获取请求的 context
+func (r *Request) Context() context.Context {
+	return r.ctx
+}
+
+// SetContext 替换请求的 context, 新的 context 应当派生自 Context() 的返回值,
+// 这样连接断开时仍能被取消
+func (r *Request) SetContext(ctx context.Context) {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r.ctx = ctx
+}
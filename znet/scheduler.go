@@ -0,0 +1,291 @@
+package znet
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// 未配置 scheduler 时时间轮的默认参数
+const (
+	defaultSchedulerTick      = 10 * time.Millisecond
+	defaultSchedulerWheelSize = 512
+)
+
+// WithTaskConn 将定时任务绑定到连接, 连接 Stop 时任务被取消; 注册时连接已经关闭则任务不会执行
+func WithTaskConn(conn ziface.IConnection) ziface.TaskOption {
+	return func(info *ziface.TaskInfo) {
+		info.Conn = conn
+	}
+}
+
+// WithTaskOnWorker 使定时任务作为一条消息进入所属连接的任务队列, 由该连接的 worker 执行,
+// 因此与该连接的消息按顺序处理, 无需加锁. 任务队列已满时同样按背压策略处理 (此时 msgId 为 0)
+func WithTaskOnWorker() ziface.TaskOption {
+	return func(info *ziface.TaskInfo) {
+		info.OnWorker = true
+	}
+}
+
+// Scheduler 为单层时间轮实现的定时任务调度器: 每个 tick 前进一格, 到期的任务交给独立的 goroutine
+// 或连接的 worker 执行, 因此任务的执行不会拖慢时间轮. 时间轮在注册第一个任务时启动
+type Scheduler struct {
+	tick    time.Duration
+	handler ziface.IMsgHandle // 执行 WithTaskOnWorker 任务的消息处理模块
+	logger  ziface.ILogger
+	metrics *Metrics
+
+	lock    sync.Mutex
+	slots   []map[*task]struct{} // 每一格中等待到期的任务
+	cursor  int                  // 当前所在的格
+	pending int                  // 时间轮中等待到期的任务数
+	started bool
+	stopped bool
+	stop    chan struct{}
+}
+
+var _ ziface.IScheduler = (*Scheduler)(nil)
+
+// task 为时间轮中的一个定时任务
+type task struct {
+	scheduler *Scheduler
+	fn        func()
+	interval  time.Duration // 周期任务的间隔, 一次性任务为 0
+	info      ziface.TaskInfo
+
+	slot      int  // 所在的格, 由 scheduler.lock 保护
+	rounds    int  // 到期前还需转过的圈数, 由 scheduler.lock 保护
+	scheduled bool // 是否在时间轮中, 由 scheduler.lock 保护
+
+	cancelled atomic.Bool
+	running   atomic.Bool // 上一次执行是否尚未结束, WithTaskOnWorker 的任务从放入任务队列起计算
+	stopConn  func() bool // 解除与连接 context 的绑定
+}
+
+// NewScheduler 按 scheduler 配置创建调度器, WithTaskOnWorker 的任务交给 handler 执行
+func NewScheduler(handler ziface.IMsgHandle) *Scheduler {
+	tick, size := settings.Conf.Scheduler.Tick, settings.Conf.Scheduler.WheelSize
+	if tick <= 0 {
+		tick = defaultSchedulerTick
+	}
+	if size <= 0 {
+		size = defaultSchedulerWheelSize
+	}
+	s := &Scheduler{
+		tick:    tick,
+		handler: handler,
+		logger:  slog.Default(),
+		slots:   make([]map[*task]struct{}, size),
+		stop:    make(chan struct{}),
+	}
+	for i := range s.slots {
+		s.slots[i] = make(map[*task]struct{})
+	}
+	return s
+}
+
+// SetLogger 设置日志
+func (s *Scheduler) SetLogger(logger ziface.ILogger) {
+	s.logger = logger
+}
+
+// After delay 之后执行一次 fn
+func (s *Scheduler) After(delay time.Duration, fn func(), opts ...ziface.TaskOption) ziface.ITask {
+	return s.schedule(delay, 0, fn, opts)
+}
+
+// Every 每隔 interval 执行一次 fn, 直到任务被取消. 周期任务不会重叠执行: 上一次执行尚未结束时跳过本次;
+// WithTaskOnWorker 的任务在任务队列中等待时同样视为尚未结束, 因此 worker 繁忙时不会堆积
+func (s *Scheduler) Every(interval time.Duration, fn func(), opts ...ziface.TaskOption) ziface.ITask {
+	return s.schedule(interval, interval, fn, opts)
+}
+
+// Len 返回等待到期的任务数
+func (s *Scheduler) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pending
+}
+
+// Stop 停止时间轮并取消全部任务, 之后注册的任务不会执行
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		return
+	}
+	s.stopped = true
+	var tasks []*task
+	for _, slot := range s.slots {
+		for t := range slot {
+			t.cancelled.Store(true)
+			t.scheduled = false
+			tasks = append(tasks, t)
+		}
+		clear(slot)
+	}
+	s.pending = 0
+	if s.started {
+		close(s.stop)
+	}
+	s.lock.Unlock()
+
+	for _, t := range tasks {
+		t.unbind()
+	}
+}
+
+// schedule 创建任务并在 delay 之后到期, interval 大于 0 时为周期任务
+func (s *Scheduler) schedule(delay, interval time.Duration, fn func(), opts []ziface.TaskOption) *task {
+	t := &task{scheduler: s, fn: fn, interval: interval}
+	for _, opt := range opts {
+		opt(&t.info)
+	}
+	if t.info.Conn != nil {
+		// 连接已经关闭时 context.AfterFunc 会立即取消任务
+		t.stopConn = context.AfterFunc(t.info.Conn.Context(), func() { t.Cancel() })
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped || t.cancelled.Load() {
+		t.cancelled.Store(true)
+		return t
+	}
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	s.add(t, delay)
+	return t
+}
+
+// add 将任务放入 delay 之后到期的格, 调用方须持有 lock
+func (s *Scheduler) add(t *task, delay time.Duration) {
+	// 至少等待一个 tick, 向上取整
+	ticks := max(int((delay+s.tick-1)/s.tick), 1)
+	t.slot = (s.cursor + ticks) % len(s.slots)
+	t.rounds = (ticks - 1) / len(s.slots)
+	t.scheduled = true
+	s.slots[t.slot][t] = struct{}{}
+	s.pending++
+}
+
+// run 每个 tick 推进一次时间轮, 直到调度器停止
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.advance()
+		}
+	}
+}
+
+// advance 前进一格, 执行其中到期的任务, 并将周期任务放回时间轮
+func (s *Scheduler) advance() {
+	s.lock.Lock()
+	s.cursor = (s.cursor + 1) % len(s.slots)
+	slot := s.slots[s.cursor]
+	var due []*task
+	for t := range slot {
+		if t.rounds > 0 {
+			t.rounds--
+			continue
+		}
+		delete(slot, t)
+		t.scheduled = false
+		s.pending--
+		due = append(due, t)
+	}
+	// 遍历结束后再放回周期任务, 避免同一格在本次遍历中再次到期
+	for _, t := range due {
+		if t.interval > 0 {
+			s.add(t, t.interval)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, t := range due {
+		s.fire(t)
+		if t.interval == 0 {
+			t.unbind()
+		}
+	}
+}
+
+// fire 执行一次到期的任务
+func (s *Scheduler) fire(t *task) {
+	if !t.running.CompareAndSwap(false, true) {
+		s.logger.Warn("scheduled task skipped, previous run not finished", "interval", t.interval)
+		return
+	}
+	if t.info.OnWorker && t.info.Conn != nil {
+		// 背压策略为 pause 时放入任务队列可能阻塞, 不能占用时间轮的 goroutine.
+		// 执行完毕或被丢弃时由 releaseRequest 清除 running
+		go s.handler.SendMsgToTaskQueue(&taskRequest{task: t})
+		return
+	}
+	go func() {
+		defer t.running.Store(false)
+		t.execute()
+	}()
+}
+
+// Cancel 取消任务, 返回任务是否因此不再执行; 一次性任务已经执行或已被取消时返回 false
+func (t *task) Cancel() bool {
+	s := t.scheduler
+	s.lock.Lock()
+	scheduled := t.scheduled && !t.cancelled.Load()
+	t.cancelled.Store(true)
+	if t.scheduled {
+		delete(s.slots[t.slot], t)
+		t.scheduled = false
+		s.pending--
+	}
+	s.lock.Unlock()
+
+	t.unbind()
+	return scheduled
+}
+
+// unbind 解除与连接 context 的绑定
+func (t *task) unbind() {
+	if t.stopConn != nil {
+		t.stopConn()
+	}
+}
+
+// execute 执行任务, 任务到期后被取消或所属连接已关闭时不执行. 任务中的 panic 只影响本次执行
+func (t *task) execute() {
+	if t.cancelled.Load() || (t.info.Conn != nil && t.info.Conn.Context().Err() != nil) {
+		return
+	}
+	s := t.scheduler
+	defer func() {
+		if r := recover(); r != nil {
+			s.metrics.panicked()
+			s.logger.Error("scheduled task panic", "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	t.fn()
+}
+
+// taskRequest 为 WithTaskOnWorker 的任务到期时放入连接任务队列的请求, 由 DoMsgHandler 直接执行
+type taskRequest struct {
+	task *task
+}
+
+func (r *taskRequest) GetConnection() ziface.IConnection { return r.task.info.Conn }
+func (r *taskRequest) GetData() []byte                   { return nil }
+func (r *taskRequest) GetMsgID() uint32                  { return 0 }
+func (r *taskRequest) Context() context.Context          { return r.task.info.Conn.Context() }
+func (r *taskRequest) SetContext(ctx context.Context)    {}
@@ -0,0 +1,118 @@
+package znet
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+func TestScheduler(t *testing.T) {
+	tick, size := settings.Conf.Scheduler.Tick, settings.Conf.Scheduler.WheelSize
+	settings.Conf.Scheduler.Tick, settings.Conf.Scheduler.WheelSize = time.Millisecond, 4
+	defer func() { settings.Conf.Scheduler.Tick, settings.Conf.Scheduler.WheelSize = tick, size }()
+
+	s := NewScheduler(NewMsgHandle())
+	defer s.Stop()
+
+	// 延迟超过一圈的任务按圈数到期
+	fired := make(chan time.Time, 1)
+	start := time.Now()
+	s.After(10*time.Millisecond, func() { fired <- time.Now() })
+	if at := <-fired; at.Sub(start) < 10*time.Millisecond {
+		t.Errorf("task fired after %v, want >= 10ms", at.Sub(start))
+	}
+
+	var runs atomic.Int32
+	every := s.Every(time.Millisecond, func() { runs.Add(1) })
+	for runs.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if !every.Cancel() {
+		t.Error("Cancel periodic task = false, want true")
+	}
+	if every.Cancel() {
+		t.Error("second Cancel = true, want false")
+	}
+
+	// 连接关闭后, 绑定到该连接的任务被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &Connection{ConnID: 0, ctx: ctx}
+	task := s.After(time.Hour, func() { t.Error("task of stopped conn fired") }, WithTaskConn(conn))
+	cancel()
+	for s.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if task.Cancel() {
+		t.Error("Cancel task of stopped conn = true, want false")
+	}
+	s.After(0, func() { t.Error("task of stopped conn fired") }, WithTaskConn(conn))
+}
+
+func TestSchedulerOnWorker(t *testing.T) {
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 1
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+	s := NewScheduler(mh)
+	defer s.Stop()
+
+	router := &blockingRouter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	mh.AddRouter(1, router)
+	router.done.Add(1)
+
+	// worker 阻塞在连接的消息上时, 该连接的任务须等到消息处理完成后才执行
+	conn := &Connection{ConnID: 0, ctx: context.Background()}
+	mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+	<-router.started
+
+	var handled atomic.Bool
+	fired := make(chan bool, 1)
+	s.After(0, func() { fired <- handled.Load() }, WithTaskConn(conn), WithTaskOnWorker())
+	time.Sleep(20 * time.Millisecond)
+	handled.Store(true)
+	close(router.release)
+	if !<-fired {
+		t.Fatal("task ran before the preceding message of its conn was handled")
+	}
+	router.done.Wait()
+}
+
+func TestSchedulerOnWorkerNoPileUp(t *testing.T) {
+	tick := settings.Conf.Scheduler.Tick
+	settings.Conf.Scheduler.Tick = time.Millisecond
+	defer func() { settings.Conf.Scheduler.Tick = tick }()
+
+	mh := NewMsgHandle()
+	mh.WorkerPoolSize = 1
+	mh.StartWorkerPool()
+	defer mh.SetWorkerPoolSize(0)
+	var full atomic.Int32
+	mh.SetOnQueueFull(func(conn ziface.IConnection, msgId uint32) { full.Add(1) })
+	s := NewScheduler(mh)
+	defer s.Stop()
+
+	router := &blockingRouter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	mh.AddRouter(1, router)
+	router.done.Add(1)
+	conn := &Connection{ConnID: 0, ctx: context.Background()}
+	mh.SendMsgToTaskQueue(NewRequest(conn, NewMsgPackage(1, nil)))
+	<-router.started
+
+	// worker 阻塞期间周期任务到期多次, 任务队列中至多等待一次执行, 其余的被跳过而不是堆积
+	var runs atomic.Int32
+	every := s.Every(time.Millisecond, func() { runs.Add(1) }, WithTaskConn(conn), WithTaskOnWorker())
+	defer every.Cancel()
+	time.Sleep(30 * time.Millisecond)
+	if n := full.Load(); n != 0 {
+		t.Fatalf("task queue full %d times, periodic runs piled up", n)
+	}
+
+	close(router.release)
+	router.done.Wait()
+	for runs.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,194 @@
+package znet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+/*
+	安全通道: 供无法使用 TLS 的客户端在普通 TCP 上获得机密性.
+
+	握手: 连接建立后, 客户端先发送 "ZSC1" + 32 字节的 X25519 公钥, 服务端回复同样格式的公钥,
+	双方通过 ECDH 得到共享密钥, 再与双方公钥 (以及可选的预共享密钥 secure_psk) 一起派生出
+	两个方向各自的 AES-256-GCM 密钥. 未配置预共享密钥时只能防御被动窃听, 无法防御中间人.
+
+	记录: 握手之后, 每个 DataPack 帧都被封装为一条记录 [4 字节密文长度][AES-GCM 密文],
+	nonce 由该方向的 8 字节序号隐式给出, 不在线路上传输. 接收方只接受序号恰好为下一个的记录,
+	因此被重放或被重排的记录都无法通过认证, 连接随即关闭.
+*/
+
+const (
+	secureMagic            = "ZSC1"
+	secureHandshakeTimeout = 5 * time.Second
+	secureRecordHeadLen    = 4
+)
+
+// secureChannel 保存一条连接两个方向上的 AEAD 及序号
+type secureChannel struct {
+	sendAEAD cipher.AEAD
+	recvAEAD cipher.AEAD
+	sendSeq  uint64
+	recvSeq  uint64
+	nonce    [12]byte // 仅由写 goroutine 使用
+	rnonce   [12]byte // 仅由读 goroutine 使用
+}
+
+// secureHandshake 在 conn 上完成密钥交换, isServer 决定收发顺序与密钥方向
+func secureHandshake(conn net.Conn, isServer bool, psk string) (*secureChannel, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(secureHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := append([]byte(secureMagic), priv.PublicKey().Bytes()...)
+	peerHello := make([]byte, len(hello))
+	if isServer {
+		if _, err := io.ReadFull(conn, peerHello); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(hello); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(hello); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, peerHello); err != nil {
+			return nil, err
+		}
+	}
+
+	if !bytes.Equal(peerHello[:len(secureMagic)], []byte(secureMagic)) {
+		return nil, errors.New("secure handshake: bad magic")
+	}
+	peerPub, err := ecdh.X25519().NewPublicKey(peerHello[len(secureMagic):])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(peerPub)
+	if err != nil {
+		return nil, err
+	}
+
+	clientHello, serverHello := peerHello, hello
+	if !isServer {
+		clientHello, serverHello = hello, peerHello
+	}
+	c2s, err := newSecureAEAD("zinx c2s", shared, psk, clientHello, serverHello)
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newSecureAEAD("zinx s2c", shared, psk, clientHello, serverHello)
+	if err != nil {
+		return nil, err
+	}
+
+	if isServer {
+		return &secureChannel{sendAEAD: s2c, recvAEAD: c2s}, nil
+	}
+	return &secureChannel{sendAEAD: c2s, recvAEAD: s2c}, nil
+}
+
+// newSecureAEAD 由共享密钥与握手内容派生出一个方向的 AES-256-GCM
+func newSecureAEAD(label string, shared []byte, psk string, clientHello, serverHello []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write([]byte(label))
+	h.Write(shared)
+	h.Write([]byte(psk))
+	h.Write(clientHello)
+	h.Write(serverHello)
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal 将一个明文帧封装为一条记录, 返回的切片取自缓冲池; 只能由单个 goroutine 调用
+func (sc *secureChannel) seal(frame []byte) *[]byte {
+	overhead := sc.sendAEAD.Overhead()
+	bp := getBuffer(secureRecordHeadLen + len(frame) + overhead)
+	out := *bp
+
+	binary.LittleEndian.PutUint32(out[:secureRecordHeadLen], uint32(len(frame)+overhead))
+	binary.BigEndian.PutUint64(sc.nonce[4:], sc.sendSeq)
+	sc.sendSeq++
+	sc.sendAEAD.Seal(out[secureRecordHeadLen:secureRecordHeadLen], sc.nonce[:], frame, out[:secureRecordHeadLen])
+	return bp
+}
+
+// open 校验并解密一条记录, 明文写回 ciphertext 所在的空间; 只能由单个 goroutine 调用
+func (sc *secureChannel) open(head, ciphertext []byte) ([]byte, error) {
+	binary.BigEndian.PutUint64(sc.rnonce[4:], sc.recvSeq)
+	plain, err := sc.recvAEAD.Open(ciphertext[:0], sc.rnonce[:], ciphertext, head)
+	if err != nil {
+		return nil, errors.New("secure record authentication failed")
+	}
+	sc.recvSeq++
+	return plain, nil
+}
+
+// secureReader 从底层连接读取记录并解密, 对上层表现为连续的明文帧流
+type secureReader struct {
+	r     io.Reader
+	sc    *secureChannel
+	head  [secureRecordHeadLen]byte
+	buf   []byte
+	plain []byte // 尚未被读走的明文
+}
+
+func newSecureReader(r io.Reader, sc *secureChannel) *secureReader {
+	return &secureReader{r: r, sc: sc}
+}
+
+func (sr *secureReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if err := sr.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+func (sr *secureReader) readRecord() error {
+	if _, err := io.ReadFull(sr.r, sr.head[:]); err != nil {
+		return err
+	}
+
+	// 一条记录最多容纳一个最大长度的帧
+	n := binary.LittleEndian.Uint32(sr.head[:])
+	maxLen := uint64(NewDataPack().GetHeadLen()) + uint64(maxPacketSize()) + uint64(sr.sc.recvAEAD.Overhead())
+	if n < uint32(sr.sc.recvAEAD.Overhead()) || uint64(n) > maxLen {
+		return errors.New("Too large secure record received")
+	}
+
+	if cap(sr.buf) < int(n) {
+		sr.buf = make([]byte, n)
+	}
+	ciphertext := sr.buf[:n]
+	if _, err := io.ReadFull(sr.r, ciphertext); err != nil {
+		return err
+	}
+
+	plain, err := sr.sc.open(sr.head[:], ciphertext)
+	if err != nil {
+		return err
+	}
+	sr.plain = plain
+	return nil
+}
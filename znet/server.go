@@ -1,8 +1,12 @@
 package znet
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 	"zinx/settings"
 	"zinx/ziface"
@@ -15,6 +19,23 @@ type Server struct {
 	Port       int                 // Port: 服务器绑定的端口
 	msgHandler ziface.IMsgHandle   // 将 Router 替换为 MsgHandler, 绑定 MsgId 与对应的处理方法
 	ConnMgr    ziface.IConnManager // 当前 Server 的连接管理器
+	scheduler  *Scheduler          // 定时任务调度器
+	limiter    *RateLimiter        // 入站消息限速器, 未配置限速时为 nil
+	ipFilter   *IPFilter           // accept 时的 IP 黑白名单与封禁
+	logger     ziface.ILogger      // Server 及其连接使用的日志
+	metrics    *Metrics            // 运行指标
+	codec      ziface.ICodec       // 类型化路由默认使用的编解码器
+
+	errorHandler ziface.ErrorHandler // 类型化路由的错误处理方法
+
+	metricsServer *http.Server // 指标的 HTTP 服务, 未配置 metrics_addr 时为 nil
+	adminServer   *http.Server // 管理后台的 HTTP 服务, 未配置 admin.addr 时为 nil
+	acceptLock    sync.Mutex   // 保证准入检查与加入连接管理器的原子性
+
+	listener  atomic.Pointer[net.TCPListener] // 监听成功后的 listener, Stop 时关闭
+	stopWatch func()                          // 注销配置热更新的回调, Stop 时调用
+
+	trustedProxies atomic.Pointer[[]*net.IPNet] // 允许发送 PROXY 头的代理网段
 
 	onConnStart func(conn ziface.IConnection) // Server 在连接创建时的 Hook 函数
 	onConnStop  func(conn ziface.IConnection) // Server 在连接删除时的 Hook 函数
@@ -27,32 +48,34 @@ var _ ziface.IServer = (*Server)(nil)
 
 // Start 开启 Server 的网络服务
 func (s *Server) Start() {
-	fmt.Printf("[START] Server listenner at IP: %s, Port %d, is starting\n", s.IP, s.Port)
-	fmt.Printf("[Zinx] Version: %s, MaxConn: %d, MaxPacketSize: %d\n",
-		settings.Conf.Version,
-		settings.Conf.MaxConn,
-		settings.Conf.MaxPacketSize)
+	s.logger.Info("server starting", "name", s.Name, "ip", s.IP, "port", s.Port,
+		"version", settings.Conf.Version,
+		"maxConn", settings.Conf.MaxConn,
+		"maxPacketSize", settings.Conf.MaxPacketSize)
 	// 开启一个 goroutine 去做服务端的 Listener 业务
 	go func() {
-		// 0. 启动 worker 工作池机制
+		// 0. 启动 worker 工作池机制, 指标服务与管理后台
 		s.msgHandler.StartWorkerPool()
+		s.serveMetrics()
+		s.serveAdmin()
 
 		// 1. 获取一个 TCP 的 Addr
 		addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
 		if err != nil {
-			fmt.Println("resolve tcp addr err: ", err)
+			s.logger.Error("resolve tcp addr failed", "err", err)
 			return
 		}
 
 		// 2. 监听服务器地址
 		listener, err := net.ListenTCP(s.IPVersion, addr)
 		if err != nil {
-			fmt.Println("listen", s.IPVersion, "err", err)
+			s.logger.Error("listen failed", "network", s.IPVersion, "err", err)
 			return
 		}
 
 		// 监听成功
-		fmt.Println("start Zinx server  ", s.Name, " succ, now listenning...")
+		s.listener.Store(listener)
+		s.logger.Info("server listening", "name", s.Name, "addr", listener.Addr().String())
 
 		// TODO: server.go 应该有一个自动生成 ID 的方法, 比如 snowflake
 		var cid uint32
@@ -62,32 +85,96 @@ func (s *Server) Start() {
 		for {
 			// 3.1 阻塞等待客户端建立连接请求
 			conn, err := listener.AcceptTCP()
-			if err != nil {
-				fmt.Println("Accept err", err)
-				continue
+			if errors.Is(err, net.ErrClosed) {
+				// Server.Stop() 关闭了 listener
+				return
 			}
-
-			// 3.2 Server.Start() 设置服务器最大连接控制, 如果超过最大连接, 则关闭此新的连接
-			if s.ConnMgr.Len() >= settings.Conf.MaxConn {
-				// 是否可以制定一个类似于 LRUCache 的连接规则 ?
-				conn.Close()
+			if err != nil {
+				s.logger.Error("accept failed", "err", err)
 				continue
 			}
 
-			// 3.3 处理该连接请求的业务方法, 此时应该有 handler 和 conn 是绑定的
-			dealConn := NewConnection(s, conn, cid, s.msgHandler)
+			connID := cid
 			cid++
-
-			go dealConn.Start()
+			if settings.Conf.ProxyProtocol.Enable {
+				// 读取 PROXY 头需要等待对端的数据, 放到独立的 goroutine 中, 避免阻塞 accept
+				go s.acceptConn(conn, connID)
+			} else {
+				s.acceptConn(conn, connID)
+			}
 		}
 	}()
 }
 
+// acceptConn 对新接受的连接做准入检查, 通过后创建 Connection 并启动
+func (s *Server) acceptConn(conn *net.TCPConn, cid uint32) {
+	// 3.2 开启了 PROXY protocol 时先解析出真实的客户端地址, 后续的检查都基于该地址
+	remoteAddr, proxyAddr := conn.RemoteAddr(), net.Addr(nil)
+	if settings.Conf.ProxyProtocol.Enable {
+		var err error
+		if remoteAddr, proxyAddr, err = s.resolveProxy(conn); err != nil {
+			s.logger.Warn("reject conn", "remote", addrString(conn.RemoteAddr()), "reason", err)
+			s.metrics.connRejected("proxy_protocol")
+			conn.Close()
+			return
+		}
+	}
+
+	// 检查与加入连接管理器须一并完成, 否则并发 accept 时可能超出连接数限制
+	s.acceptLock.Lock()
+	defer s.acceptLock.Unlock()
+
+	// 3.3 Server.Start() 设置服务器最大连接控制, 如果超过最大连接, 则关闭此新的连接
+	if s.ConnMgr.Len() >= settings.Conf.MaxConn {
+		// 是否可以制定一个类似于 LRUCache 的连接规则 ?
+		s.logger.Warn("reject conn", "remote", addrString(remoteAddr), "reason", "too many connections")
+		s.metrics.connRejected("max_conn")
+		conn.Close()
+		return
+	}
+
+	// 3.4 按照 IP 黑白名单, 封禁以及单 IP 最大连接数过滤
+	ip := remoteAddr.(*net.TCPAddr).IP
+	if !s.ipFilter.Allowed(ip) {
+		s.logger.Warn("reject conn", "remote", addrString(remoteAddr), "reason", "ip not allowed")
+		s.metrics.connRejected("ip_not_allowed")
+		conn.Close()
+		return
+	}
+	if maxConn := settings.Conf.MaxConnPerIP; maxConn > 0 && s.ConnMgr.LenByIP(ip.String()) >= maxConn {
+		s.logger.Warn("reject conn", "remote", addrString(remoteAddr), "reason", "too many connections from ip")
+		s.metrics.connRejected("max_conn_per_ip")
+		conn.Close()
+		return
+	}
+
+	// 3.5 处理该连接请求的业务方法, 此时应该有 handler 和 conn 是绑定的
+	dealConn := newConnection(s, conn, cid, s.msgHandler, remoteAddr, proxyAddr)
+	s.metrics.connAccepted()
+
+	go dealConn.Start()
+}
+
 func (s *Server) Stop() {
-	fmt.Println("[STOP] Zinx server , name ", s.Name)
+	s.logger.Info("server stopping", "name", s.Name)
 
 	// Server.Stop() 将其它需要清理的连接信息或其他信息一并停止或清理
+	if listener := s.listener.Swap(nil); listener != nil {
+		listener.Close()
+	}
+	// 停止的 Server 不再响应配置热更新
+	s.stopWatch()
 	s.ConnMgr.ClearConn()
+	s.scheduler.Stop()
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		mh.stopTick()
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
 }
 
 func (s *Server) Serve() {
@@ -101,29 +188,116 @@ func (s *Server) Serve() {
 	}
 }
 
-func (s *Server) AddRouter(msgId uint32, router ziface.IRouter) {
-	s.msgHandler.AddRouter(msgId, router)
-	fmt.Println("Add Router succ! msgId = ", msgId)
+func (s *Server) AddRouter(msgId uint32, router ziface.IRouter, opts ...ziface.RouteOption) {
+	s.msgHandler.AddRouter(msgId, router, opts...)
+	s.logger.Info("router added", "msgID", msgId)
+}
+
+// ReplaceRouter 在运行时注册或替换路由, 返回被替换的 Router
+func (s *Server) ReplaceRouter(msgId uint32, router ziface.IRouter, opts ...ziface.RouteOption) ziface.IRouter {
+	return s.msgHandler.ReplaceRouter(msgId, router, opts...)
+}
+
+// RemoveRouter 在运行时删除路由
+func (s *Server) RemoveRouter(msgId uint32) error {
+	return s.msgHandler.RemoveRouter(msgId)
+}
+
+// Group 创建包含 msgId 范围 [start, end] 的路由分组, 组内可以注册路由, 中间件与默认处理逻辑
+func (s *Server) Group(start, end uint32) ziface.IRouteGroup {
+	return s.msgHandler.Group(start, end)
+}
+
+// Routes 获取全部路由注册信息的快照
+func (s *Server) Routes() []ziface.RouteInfo {
+	return s.msgHandler.Routes()
+}
+
+// SetWorkerPoolSize 运行时调整工作池的 worker 数量, 队列中尚未处理的消息会迁移到新的 worker
+func (s *Server) SetWorkerPoolSize(size uint32) {
+	s.msgHandler.SetWorkerPoolSize(size)
+}
+
+// GetWorkerPoolSize 获取当前工作池的 worker 数量
+func (s *Server) GetWorkerPoolSize() uint32 {
+	return s.msgHandler.GetWorkerPoolSize()
+}
+
+// Addr 获取服务器实际监听的地址, 如配置端口为 0 时由系统分配的端口, 尚未开始监听时为 nil
+func (s *Server) Addr() net.Addr {
+	if listener := s.listener.Load(); listener != nil {
+		return listener.Addr()
+	}
+	return nil
 }
 
 func (s *Server) GetConnMgr() ziface.IConnManager {
 	return s.ConnMgr
 }
 
+// GetScheduler 得到定时任务调度器
+func (s *Server) GetScheduler() ziface.IScheduler {
+	return s.scheduler
+}
+
 // NewServer 将创建一个服务器的 Handler
 func NewServer() ziface.IServer {
+	mh := NewMsgHandle()
 	s := &Server{
 		Name:       settings.Conf.Name,
 		IPVersion:  "tcp4",
 		IP:         settings.Conf.Host,
 		Port:       settings.Conf.Port,
-		msgHandler: NewMsgHandle(),
+		msgHandler: mh,
 		ConnMgr:    NewConnManager(),
+		scheduler:  NewScheduler(mh),
+		limiter:    NewRateLimiter(),
+		metrics:    NewMetrics(),
+		codec:      CodecByName(settings.Conf.Codec),
+	}
+	s.SetErrorHandler(nil)
+	mh.metrics = s.metrics
+	s.scheduler.metrics = s.metrics
+	s.SetLogger(NewLogger())
+
+	var err error
+	if s.ipFilter, err = NewIPFilter(); err != nil {
+		s.logger.Error("load ip filter failed", "err", err)
 	}
+	s.loadTrustedProxies()
+
+	// 配置文件热更新时按 worker_pool_size 调整工作池, 重新加载黑白名单与受信代理, 并断开不再被允许的连接
+	s.stopWatch = settings.OnChange(func() {
+		s.msgHandler.SetWorkerPoolSize(settings.Conf.WorkerPoolSize)
+		s.loadTrustedProxies()
+		if err := s.ipFilter.Reload(); err != nil {
+			s.logger.Error("reload ip filter failed", "err", err)
+			return
+		}
+		s.kickConns(func(ip net.IP) bool { return !s.ipFilter.Allowed(ip) })
+	})
 
 	return s
 }
 
+// SetLogger 设置 Server 使用的日志, 同时作用于消息处理模块与连接管理器
+func (s *Server) SetLogger(logger ziface.ILogger) {
+	s.logger = logger
+	s.msgHandler.SetLogger(logger)
+	s.ConnMgr.SetLogger(logger)
+	s.scheduler.SetLogger(logger)
+}
+
+// SetTracer 设置追踪钩子, 为 nil 时关闭追踪
+func (s *Server) SetTracer(tracer ziface.ITracer) {
+	s.msgHandler.SetTracer(tracer)
+}
+
+// GetLogger 获取 Server 使用的日志
+func (s *Server) GetLogger() ziface.ILogger {
+	return s.logger
+}
+
 func (s *Server) SetOnConnStart(hookFunc func(ziface.IConnection)) {
 	s.onConnStart = hookFunc
 }
@@ -132,16 +306,81 @@ func (s *Server) SetOnConnStop(hookFunc func(ziface.IConnection)) {
 	s.onConnStop = hookFunc
 }
 
+// SetOnQueueFull 设置任务队列已满时的 hook 函数, 在连接的读 goroutine 中调用, 应尽快返回
+func (s *Server) SetOnQueueFull(hookFunc func(conn ziface.IConnection, msgId uint32)) {
+	s.msgHandler.SetOnQueueFull(hookFunc)
+}
+
+// SetOnTimeout 设置路由处理超时的 hook 函数, 在 worker 中调用, 应尽快返回
+func (s *Server) SetOnTimeout(hookFunc func(conn ziface.IConnection, msgId uint32, timeout time.Duration)) {
+	s.msgHandler.SetOnTimeout(hookFunc)
+}
+
+// SetOnTick 设置 dispatch_mode 为 tick 时每个 tick 调用的回调, 在 tick goroutine 中以该 tick 收集的全部消息调用
+func (s *Server) SetOnTick(hookFunc func(tick uint64, requests []ziface.IRequest)) {
+	s.msgHandler.SetOnTick(hookFunc)
+}
+
+// SetAuthenticator 设置认证方法, 连接须先通过 loginMsgId 登录, 之后才能调用白名单以外的消息;
+// 超过 auth_timeout 仍未登录的连接会被关闭
+func (s *Server) SetAuthenticator(loginMsgId uint32, auth ziface.Authenticator) {
+	s.msgHandler.SetAuthenticator(loginMsgId, auth)
+}
+
+// loadTrustedProxies 从 settings.Conf 加载受信代理网段, 解析失败时保留原有配置
+func (s *Server) loadTrustedProxies() {
+	nets, err := parseCIDRs(settings.Conf.ProxyProtocol.TrustedProxies)
+	if err != nil {
+		s.logger.Error("load trusted proxies failed", "err", err)
+		if s.trustedProxies.Load() == nil {
+			s.trustedProxies.Store(&[]*net.IPNet{})
+		}
+		return
+	}
+	s.trustedProxies.Store(&nets)
+}
+
+// BanIP 在 duration 内封禁 ip (duration <= 0 表示永久), 并断开该 IP 当前的全部连接
+func (s *Server) BanIP(ip string, duration time.Duration) error {
+	bannedIP := net.ParseIP(ip)
+	if bannedIP == nil {
+		return errors.New("invalid ip: " + ip)
+	}
+
+	s.ipFilter.Ban(bannedIP, duration)
+	s.kickConns(func(ip net.IP) bool { return ip.Equal(bannedIP) })
+	return nil
+}
+
+// UnbanIP 解除对 ip 的封禁
+func (s *Server) UnbanIP(ip string) error {
+	bannedIP := net.ParseIP(ip)
+	if bannedIP == nil {
+		return errors.New("invalid ip: " + ip)
+	}
+
+	s.ipFilter.Unban(bannedIP)
+	return nil
+}
+
+// kickConns 断开远程 IP 满足 match 的全部连接
+func (s *Server) kickConns(match func(ip net.IP) bool) {
+	for _, conn := range s.ConnMgr.GetAll() {
+		if match(net.ParseIP(remoteIP(conn.RemoteAddr()))) {
+			s.logger.Info("kick conn", "connID", conn.GetConnID(), "remote", addrString(conn.RemoteAddr()))
+			conn.Stop()
+		}
+	}
+}
+
 func (s *Server) CallOnConnStart(conn ziface.IConnection) {
 	if s.onConnStart != nil {
-		fmt.Println("---> CallOnConnStart ...")
 		s.onConnStart(conn)
 	}
 }
 
 func (s *Server) CallOnConnStop(conn ziface.IConnection) {
 	if s.onConnStop != nil {
-		fmt.Println("---> CallOnConnStop ...")
 		s.onConnStop(conn)
 	}
 }
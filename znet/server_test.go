@@ -1,47 +1,128 @@
 package znet
 
 import (
-	"fmt"
+	"context"
+	"io"
 	"net"
 	"testing"
 	"time"
+	"zinx/settings"
+	"zinx/ziface"
 )
 
-func ClientTest() {
-	fmt.Println("Client Test ... start")
-	//3秒之后发起测试请求，给服务端开启服务的机会
-	time.Sleep(3 * time.Second)
+// ctxRouter 回写 pong, 并把请求的 context 交给测试用例检查
+type ctxRouter struct {
+	BaseRouter
+	ctxs chan context.Context
+}
+
+func (r *ctxRouter) Handle(request ziface.IRequest) {
+	_ = request.GetConnection().SendBuffMsg(0, []byte("pong"))
+	r.ctxs <- request.Context()
+}
+
+// serverAddr 等待服务端在 goroutine 中开始监听, 返回其实际监听的地址
+func serverAddr(t *testing.T, s ziface.IServer) string {
+	for i := 0; i < 50; i++ {
+		if addr := s.Addr(); addr != nil {
+			return addr.String()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("server not listening")
+	return ""
+}
 
-	conn, err := net.Dial("tcp", "127.0.0.1:7777")
+// dialTest 连接服务端实际监听的地址
+func dialTest(t *testing.T, s ziface.IServer) net.Conn {
+	conn, err := net.Dial("tcp", serverAddr(t, s))
 	if err != nil {
-		fmt.Println("client start err, exit!")
-		return
+		t.Fatal(err)
 	}
+	return conn
+}
 
-	for {
-		_, err := conn.Write([]byte("hello ZINX"))
-		if err != nil {
-			fmt.Println("Write error err", err)
-			return
-		}
+// listenTest 让接下来创建的 Server 监听 127.0.0.1 上由系统分配的端口, 测试结束后恢复配置
+func listenTest(t *testing.T) {
+	host, port, maxConn := settings.Conf.Host, settings.Conf.Port, settings.Conf.MaxConn
+	t.Cleanup(func() { settings.Conf.Host, settings.Conf.Port, settings.Conf.MaxConn = host, port, maxConn })
+	settings.Conf.Host, settings.Conf.Port, settings.Conf.MaxConn = "127.0.0.1", 0, 3
+}
 
-		buf := make([]byte, 512)
-		cnt, err := conn.Read(buf)
-		if err != nil {
-			fmt.Println("read buf error ")
-			return
-		}
+func TestServer(t *testing.T) {
+	listenTest(t)
+	chanLen := settings.Conf.MaxMsgChanLen
+	settings.Conf.MaxMsgChanLen = 10
+	defer func() { settings.Conf.MaxMsgChanLen = chanLen }()
+
+	router := &ctxRouter{ctxs: make(chan context.Context, 1)}
+	s := NewServer()
+	s.AddRouter(0, router)
+	s.Start()
+	defer s.Stop()
 
-		fmt.Printf(" server call back: %s, cnt = %d\n", buf, cnt)
+	conn := dialTest(t, s)
+	defer conn.Close()
 
-		time.Sleep(1 * time.Second)
+	dp := NewDataPack()
+	msg, _ := dp.Pack(NewMsgPackage(0, []byte("ping")))
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestServer(t *testing.T) {
-	s := NewServer("[zinx V0.1]") // 创建一个 server Handler
+	headData := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(conn, headData); err != nil {
+		t.Fatal(err)
+	}
+	head, err := dp.Unpack(headData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, head.GetDataLen())
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "pong" {
+		t.Fatalf("recv %q, want pong", data)
+	}
 
-	go ClientTest() // 启动客户端
+	ctx := <-router.ctxs
+	if ctx.Err() != nil {
+		t.Fatal("request context cancelled while connection alive")
+	}
 
-	s.Serve() // 开启服务
+	// 客户端断开后, 连接 Stop, 请求的 context 随之取消
+	conn.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("request context not cancelled after connection stop")
+	}
+}
+
+func TestStopDuringOnConnStart(t *testing.T) {
+	listenTest(t)
+	events := make(chan string, 3)
+	s := NewServer()
+	// OnConnStart 执行期间连接被 Stop, OnConnStop 仍须在 OnConnStart 结束后调用
+	s.SetOnConnStart(func(conn ziface.IConnection) {
+		conn.Stop()
+		events <- "start"
+	})
+	s.SetOnConnStop(func(conn ziface.IConnection) { events <- "stop" })
+	s.Start()
+	defer s.Stop()
+
+	conn := dialTest(t, s)
+	defer conn.Close()
+	for _, want := range []string{"start", "stop"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("hook = %s, want %s", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("hook %s not called", want)
+		}
+	}
 }
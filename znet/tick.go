@@ -0,0 +1,177 @@
+package znet
+
+import (
+	"runtime/debug"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// defaultTickInterval 为未配置 tick.interval 时 DispatchTick 模式的 tick 间隔
+const defaultTickInterval = 50 * time.Millisecond
+
+// SetOnTick 设置 DispatchTick 模式的 tick 回调, 每个 tick 在 tick goroutine 中以该 tick 收集的全部消息调用一次.
+// tick 为从 1 开始的序号, 因超时被跳过的 tick 也计入序号, 因此 tick * interval 即为逻辑时间.
+// requests 及其中的请求在回调返回后被归还, 回调不能继续持有
+func (mh *MsgHandle) SetOnTick(hook func(tick uint64, requests []ziface.IRequest)) {
+	mh.onTick = hook
+}
+
+// tickInterval 返回 tick 的间隔
+func tickInterval() time.Duration {
+	if interval := settings.Conf.Tick.Interval; interval > 0 {
+		return interval
+	}
+	return defaultTickInterval
+}
+
+// startTick 启动 tick goroutine, 已经启动时不做处理
+func (mh *MsgHandle) startTick() {
+	if mh.tickStarted.CompareAndSwap(false, true) {
+		go mh.runTicks()
+	}
+}
+
+// stopTick 停止 tick goroutine, 当前 tick 处理完成后退出
+func (mh *MsgHandle) stopTick() {
+	mh.tickStopOnce.Do(func() { close(mh.tickStop) })
+}
+
+// sendToTick 将消息放入当前 tick 的 bucket, bucket 已满时按背压策略处理, pause 策略下等到下一个 tick 取走 bucket
+func (mh *MsgHandle) sendToTick(request ziface.IRequest) {
+	if mh.appendTick(request) {
+		return
+	}
+	mh.queueFull(request, func() bool {
+		for {
+			mh.tickLock.Lock()
+			swapped := mh.tickSwap
+			mh.tickLock.Unlock()
+			select {
+			case <-swapped:
+			case <-request.GetConnection().Context().Done():
+				return false
+			}
+			if mh.appendTick(request) {
+				return true
+			}
+		}
+	})
+}
+
+// appendTick 在 bucket 未满时放入消息, 返回是否放入
+func (mh *MsgHandle) appendTick(request ziface.IRequest) bool {
+	mh.tickLock.Lock()
+	defer mh.tickLock.Unlock()
+	if limit := settings.Conf.Tick.MaxBucket; limit > 0 && len(mh.tickBucket) >= limit {
+		return false
+	}
+	mh.tickBucket = append(mh.tickBucket, request)
+	return true
+}
+
+// runTicks 以固定频率运行 tick: 按计划时间而非上一个 tick 结束的时间计算下一个 tick,
+// tick 超过间隔时记录 overrun, 落后整数个间隔时跳过这些 tick 而不是连续补齐
+func (mh *MsgHandle) runTicks() {
+	interval := tickInterval()
+	mh.logger.Info("tick loop started", "interval", interval)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	var (
+		tick  uint64
+		spare []ziface.IRequest // 上一个 tick 的 bucket, 清空后复用
+	)
+	next := time.Now().Add(interval)
+	for {
+		select {
+		case <-mh.tickStop:
+			return
+		case <-timer.C:
+		}
+		tick++
+
+		mh.tickLock.Lock()
+		bucket := mh.tickBucket
+		mh.tickBucket = spare[:0]
+		close(mh.tickSwap)
+		mh.tickSwap = make(chan struct{})
+		mh.tickLock.Unlock()
+
+		start := time.Now()
+		mh.processTick(tick, bucket)
+		elapsed := time.Since(start)
+		clear(bucket)
+		spare = bucket
+
+		// 下一个 tick 的计划时间已过去整数个间隔时, 跳过这些 tick; 不足一个间隔时立即开始下一个 tick
+		var skipped uint64
+		next = next.Add(interval)
+		if behind := time.Since(next); behind >= interval {
+			skipped = uint64(behind / interval)
+			next = next.Add(time.Duration(skipped) * interval)
+		}
+		overrun := elapsed > interval
+		if overrun {
+			mh.logger.Warn("tick overrun", "tick", tick, "duration", elapsed, "interval", interval,
+				"requests", len(bucket), "skipped", skipped)
+		}
+		mh.metrics.tickDone(elapsed, len(bucket), overrun, skipped)
+		tick += skipped
+		timer.Reset(time.Until(next))
+	}
+}
+
+// processTick 处理一个 tick 收集的消息: 定时任务, 登录消息以及未设置 tick 回调时的消息按顺序交给 DoMsgHandler,
+// 未通过授权的消息被丢弃, 其余消息按到达顺序交给 tick 回调
+func (mh *MsgHandle) processTick(tick uint64, bucket []ziface.IRequest) {
+	input := make([]ziface.IRequest, 0, len(bucket))
+	spans := make([]ziface.ISpan, 0, len(bucket))
+	for _, request := range bucket {
+		if !mh.tickInput(request) {
+			mh.DoMsgHandler(request)
+			continue
+		}
+
+		route, _ := mh.lookupRoute(request.GetMsgID())
+		if route == nil {
+			route = &ziface.RouteInfo{MsgId: request.GetMsgID()}
+		}
+		if !mh.authorized(request, route) {
+			mh.traceDrop(request, ErrUnauthorized)
+			mh.logger.Warn("api not authorized", "connID", request.GetConnection().GetConnID(),
+				"remote", addrString(request.GetConnection().RemoteAddr()), "msgID", request.GetMsgID())
+			releaseRequest(request)
+			continue
+		}
+		input = append(input, request)
+		spans = append(spans, mh.traceBegin(request))
+	}
+	mh.runTick(tick, input)
+	for i, request := range input {
+		spans[i].End()
+		releaseRequest(request)
+	}
+}
+
+// tickInput 判断消息是否交给 tick 回调
+func (mh *MsgHandle) tickInput(request ziface.IRequest) bool {
+	if _, ok := request.(*taskRequest); ok || mh.onTick == nil {
+		return false
+	}
+	return mh.authenticator == nil || request.GetMsgID() != mh.loginMsgId
+}
+
+// runTick 调用 tick 回调, 没有消息时同样调用; 回调中的 panic 只影响当前 tick
+func (mh *MsgHandle) runTick(tick uint64, input []ziface.IRequest) {
+	if mh.onTick == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			mh.metrics.panicked()
+			mh.logger.Error("tick panic", "tick", tick, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	mh.onTick(tick, input)
+}
@@ -0,0 +1,96 @@
+package znet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// ErrHandlerTimeout 为路由处理超时时记录在请求 span 上的错误
+var ErrHandlerTimeout = errors.New("handler timeout")
+
+// WithTimeout 为路由的处理链设置超时时间. 处理链在独立的 goroutine 中执行, 请求的 context 在超时后被取消;
+// 超时后 worker 不再等待, 调用超时 hook, 配置了 timeout_msg_id 时回复客户端, 然后继续处理后续消息.
+// 因此处理链应当响应 request.Context() 的取消, 超时的处理链与同一连接的后续消息可能并发执行;
+// DispatchOrdered 模式保证连接内消息的顺序, 不支持该选项, 注册时 panic
+func WithTimeout(timeout time.Duration) ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.Timeout = timeout
+	}
+}
+
+// SetOnTimeout 设置路由处理超时的 hook 函数, 在 worker 中调用, 应尽快返回
+func (mh *MsgHandle) SetOnTimeout(hook func(conn ziface.IConnection, msgId uint32, timeout time.Duration)) {
+	mh.onTimeout = hook
+}
+
+// 超时处理链的状态, 决定由谁归还请求
+const (
+	handlerRunning int32 = iota
+	handlerFinished
+	handlerAbandoned
+)
+
+// executeTimeout 在独立的 goroutine 中执行处理链并最多等待 route.Timeout, 返回调用方是否应结束 span 并归还请求:
+// 处理链按时结束时为 true; 超时后为 false, 此时由处理链在结束时记录超时错误, 结束 span 并归还请求
+func (mh *MsgHandle) executeTimeout(request ziface.IRequest, route *ziface.RouteInfo, group *RouteGroup, span ziface.ISpan) bool {
+	r, ok := request.(*Request)
+	if !ok {
+		mh.execute(request, route, group, span)
+		return true
+	}
+
+	// 超时后处理链可能随时归还请求, 先取出超时处理需要的信息
+	conn, msgId := r.GetConnection(), r.GetMsgID()
+	ctx, cancel := context.WithTimeout(r.ctx, route.Timeout)
+	r.ctx = ctx
+
+	var state atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		mh.execute(request, route, group, span)
+		if !state.CompareAndSwap(handlerRunning, handlerFinished) {
+			// worker 已经放弃等待, span 的结束时间覆盖整个处理链
+			span.RecordError(ErrHandlerTimeout)
+			span.End()
+			releaseRequest(request)
+		}
+	}()
+
+	timer := time.NewTimer(route.Timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+	}
+	if !state.CompareAndSwap(handlerRunning, handlerAbandoned) {
+		// 处理链恰好在超时的同时结束
+		<-done
+		return true
+	}
+
+	mh.handlerTimeout(conn, msgId, route.Timeout)
+	return false
+}
+
+// handlerTimeout 记录处理超时, 调用超时 hook, 配置了 timeout_msg_id 时回复客户端, 数据为超时消息的 msgId
+func (mh *MsgHandle) handlerTimeout(conn ziface.IConnection, msgId uint32, timeout time.Duration) {
+	mh.metrics.handlerTimedOut()
+	mh.logger.Warn("handler timeout", "connID", conn.GetConnID(),
+		"remote", addrString(conn.RemoteAddr()), "msgID", msgId, "timeout", timeout)
+	if mh.onTimeout != nil {
+		mh.onTimeout(conn, msgId, timeout)
+	}
+	if replyMsgId := settings.Conf.TimeoutMsgId; replyMsgId != 0 {
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, msgId)
+		_ = conn.TrySendBuffMsg(replyMsgId, data)
+	}
+}
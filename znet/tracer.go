@@ -0,0 +1,211 @@
+package znet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+	"zinx/ziface"
+)
+
+// traceContextLen 为消息帧中追踪上下文扩展的长度: 16 字节 TraceID + 8 字节 SpanID.
+// 带 FlagTraceContext 标志的消息, 其数据以追踪上下文开头, 之后才是真正的消息数据
+const traceContextLen = 24
+
+var (
+	ErrRouteNotFound = errors.New("route not found")      // 消息没有注册对应的 Router
+	ErrUnauthorized  = errors.New("route not authorized") // 连接无权调用该消息
+)
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext 返回携带追踪上下文 sc 的 ctx, 用于向 ITracer 传递远程的父 span
+func ContextWithSpanContext(ctx context.Context, sc ziface.SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext 取出 ctx 携带的追踪上下文, 没有时返回零值
+func SpanContextFromContext(ctx context.Context) ziface.SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(ziface.SpanContext)
+	return sc
+}
+
+// putTraceContext 将追踪上下文写入 buf 的前 traceContextLen 个字节
+func putTraceContext(buf []byte, sc ziface.SpanContext) {
+	copy(buf[0:16], sc.TraceID[:])
+	copy(buf[16:24], sc.SpanID[:])
+}
+
+// readTraceContext 从 buf 的前 traceContextLen 个字节读出追踪上下文
+func readTraceContext(buf []byte) ziface.SpanContext {
+	var sc ziface.SpanContext
+	copy(sc.TraceID[:], buf[0:16])
+	copy(sc.SpanID[:], buf[16:24])
+	return sc
+}
+
+// noopSpan 为未设置 ITracer 时使用的 span, 不做任何事
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value any) {}
+func (noopSpan) RecordError(err error)              {}
+func (noopSpan) End()                               {}
+func (noopSpan) SpanContext() ziface.SpanContext    { return ziface.SpanContext{} }
+
+// startRequestSpan 为请求创建 zinx.request span, 客户端带来的追踪上下文作为其父 span
+func (mh *MsgHandle) startRequestSpan(r *Request) {
+	ctx := r.ctx
+	if r.traceParent.IsValid() {
+		ctx = ContextWithSpanContext(ctx, r.traceParent)
+	}
+	r.ctx, r.span = mh.tracer.Start(ctx, "zinx.request")
+	r.span.SetAttribute("zinx.conn_id", r.conn.GetConnID())
+	r.span.SetAttribute("zinx.msg_id", r.GetMsgID())
+	r.span.SetAttribute("net.peer.addr", addrString(r.conn.RemoteAddr()))
+}
+
+// traceEnqueue 在请求进入任务队列时创建请求 span, 并开始记录队列等待的 zinx.queue span
+func (mh *MsgHandle) traceEnqueue(request ziface.IRequest) {
+	r, ok := request.(*Request)
+	if mh.tracer == nil || !ok {
+		return
+	}
+	mh.startRequestSpan(r)
+	_, r.queueSpan = mh.tracer.Start(r.ctx, "zinx.queue")
+}
+
+// traceDrop 在请求未经处理就被丢弃时以 err 结束请求的全部 span
+func (mh *MsgHandle) traceDrop(request ziface.IRequest, err error) {
+	r, ok := request.(*Request)
+	if !ok {
+		return
+	}
+	if r.queueSpan != nil {
+		r.queueSpan.End()
+		r.queueSpan = nil
+	}
+	if r.span != nil {
+		r.span.RecordError(err)
+		r.span.End()
+		r.span = nil
+	}
+}
+
+// traceBegin 在处理链开始前结束队列等待 span, 返回请求 span; 未设置 ITracer 时返回 noopSpan
+func (mh *MsgHandle) traceBegin(request ziface.IRequest) ziface.ISpan {
+	r, ok := request.(*Request)
+	if mh.tracer == nil || !ok {
+		return noopSpan{}
+	}
+	if r.queueSpan != nil {
+		r.queueSpan.End()
+		r.queueSpan = nil
+	}
+	if r.span == nil {
+		mh.startRequestSpan(r)
+	}
+	return r.span
+}
+
+// tracePhase 在名为 name 的子 span 中执行处理链的一个阶段, 执行期间请求的 context 携带该 span.
+// 阶段结束后恢复原来的 context; 阶段中通过 SetContext 替换了 context (如 PreHandle 附加用户信息) 时保留替换后的 context,
+// 其派生自阶段的 context, 因此之后阶段的 span 成为该阶段 span 的子 span
+func (mh *MsgHandle) tracePhase(request ziface.IRequest, name string, phase func(ziface.IRequest)) {
+	r, ok := request.(*Request)
+	if !ok || r.span == nil {
+		phase(request)
+		return
+	}
+
+	parent := r.ctx
+	ctx, span := mh.tracer.Start(parent, name)
+	r.ctx = ctx
+	defer func() {
+		if r.ctx == ctx {
+			r.ctx = parent
+		}
+		span.End()
+	}()
+	phase(request)
+}
+
+// MemoryTracer 为把 span 记录在内存中的 ITracer, 用于测试与调试
+type MemoryTracer struct {
+	lock  sync.Mutex
+	spans []*MemorySpan // 已结束的 span, 按结束顺序排列
+}
+
+var _ ziface.ITracer = (*MemoryTracer)(nil)
+
+// MemorySpan 为 MemoryTracer 记录的 span
+type MemorySpan struct {
+	Name       string
+	Context    ziface.SpanContext
+	Parent     ziface.SpanContext // 父 span 的追踪上下文, 根 span 为零值
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]any
+	Err        error
+
+	tracer *MemoryTracer
+}
+
+// NewMemoryTracer 创建 MemoryTracer
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+// Start 实现 ziface.ITracer, 以 ctx 中的追踪上下文为父 span 创建新的 span
+func (t *MemoryTracer) Start(ctx context.Context, name string) (context.Context, ziface.ISpan) {
+	parent := SpanContextFromContext(ctx)
+	span := &MemorySpan{
+		Name:       name,
+		Parent:     parent,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+	} else {
+		binary.LittleEndian.PutUint64(span.Context.TraceID[0:8], rand.Uint64())
+		binary.LittleEndian.PutUint64(span.Context.TraceID[8:16], rand.Uint64())
+	}
+	binary.LittleEndian.PutUint64(span.Context.SpanID[:], rand.Uint64()|1)
+	return ContextWithSpanContext(ctx, span.Context), span
+}
+
+// Spans 返回全部已结束的 span
+func (t *MemoryTracer) Spans() []*MemorySpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]*MemorySpan(nil), t.spans...)
+}
+
+// Reset 清空已记录的 span
+func (t *MemoryTracer) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.spans = nil
+}
+
+func (s *MemorySpan) SetAttribute(key string, value any) {
+	s.Attributes[key] = value
+}
+
+func (s *MemorySpan) RecordError(err error) {
+	s.Err = err
+}
+
+func (s *MemorySpan) End() {
+	s.EndTime = time.Now()
+	s.tracer.lock.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.lock.Unlock()
+}
+
+func (s *MemorySpan) SpanContext() ziface.SpanContext {
+	return s.Context
+}
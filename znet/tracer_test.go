@@ -0,0 +1,91 @@
+package znet
+
+import (
+	"context"
+	"testing"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+func TestTracePropagation(t *testing.T) {
+	listenTest(t)
+
+	tracer := NewMemoryTracer()
+	router := &ctxRouter{ctxs: make(chan context.Context, 1)}
+	s := NewServer()
+	s.SetTracer(tracer)
+	s.AddRouter(0, router)
+	s.Start()
+	defer s.Stop()
+
+	client := NewClient(serverAddr(t, s))
+	client.TraceContext = true
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	parent := ziface.SpanContext{TraceID: [16]byte{1, 2, 3}, SpanID: [8]byte{4, 5, 6}}
+	if err := client.SendMsgContext(ContextWithSpanContext(context.Background(), parent), 0, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := client.ReadMsg(); err != nil || string(msg.GetData()) != "pong" {
+		t.Fatalf("recv %v, %v", msg, err)
+	}
+
+	// Handle 中请求的 context 携带 zinx.handle span, 与客户端属于同一条 trace
+	if sc := SpanContextFromContext(<-router.ctxs); sc.TraceID != parent.TraceID {
+		t.Fatalf("handler trace id = %x", sc.TraceID)
+	}
+
+	spans := map[string]*MemorySpan{}
+	for deadline := time.Now().Add(3 * time.Second); spans["zinx.request"] == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		for _, span := range tracer.Spans() {
+			spans[span.Name] = span
+		}
+	}
+	request := spans["zinx.request"]
+	if request == nil || request.Parent != parent {
+		t.Fatalf("request span %+v not linked to client span", request)
+	}
+	for _, name := range []string{"zinx.prehandle", "zinx.handle", "zinx.posthandle"} {
+		if span := spans[name]; span == nil || span.Parent != request.Context {
+			t.Fatalf("%s span %+v not a child of request span", name, span)
+		}
+	}
+	if settings.Conf.WorkerPoolSize > 0 && spans["zinx.queue"] == nil {
+		t.Fatal("queue span missing")
+	}
+}
+
+type userKey struct{}
+
+// userRouter 在 PreHandle 中为请求的 context 附加用户信息, 并记录 Handle 中读到的值
+type userRouter struct {
+	BaseRouter
+	user any
+}
+
+func (r *userRouter) PreHandle(request ziface.IRequest) {
+	request.SetContext(context.WithValue(request.Context(), userKey{}, "user-1"))
+}
+
+func (r *userRouter) Handle(request ziface.IRequest) {
+	r.user = request.Context().Value(userKey{})
+}
+
+func TestTracePhaseKeepsContext(t *testing.T) {
+	// 无论是否设置了追踪钩子, PreHandle 附加的 context 都应传递到 Handle
+	for _, tracer := range []ziface.ITracer{nil, NewMemoryTracer()} {
+		mh := NewMsgHandle()
+		mh.SetTracer(tracer)
+		router := &userRouter{}
+		mh.AddRouter(1, router)
+		mh.DoMsgHandler(NewRequest(&Connection{ctx: context.Background()}, NewMsgPackage(1, nil)))
+		if router.user != "user-1" {
+			t.Errorf("tracer %T: Handle saw user %v, want user-1", tracer, router.user)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package znet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/settings"
+	"zinx/ziface"
+)
+
+// worker 为工作池中的一个 worker
+type worker struct {
+	id      int
+	queue   lanes          // DispatchHash 模式下按 ConnID 分配的有序任务队列
+	local   lanes          // DispatchHash 模式下无序路由的任务队列, 空闲的 worker 可以从中窃取任务
+	skipped int            // 连续优先处理高优先级消息的次数, 只由 worker 自己读写
+	stop    chan struct{}  // 关闭后 worker 在处理完当前消息后退出
+	done    chan struct{}  // worker 退出后关闭
+	waiters sync.WaitGroup // pause 策略下不持有 poolLock 等待该 worker 队列空位的分发方
+	stats   *workerStats
+}
+
+// lane 返回 worker 中消息应放入的通道
+func (w *worker) lane(lane int, unordered bool) chan ziface.IRequest {
+	if unordered {
+		return w.local[lane]
+	}
+	return w.queue[lane]
+}
+
+// workerStats 为 worker 的运行统计, 工作池调整大小后编号相同的 worker 沿用原有统计
+type workerStats struct {
+	since   time.Time     // 统计开始时间
+	busy    atomic.Int64  // 处理消息的累计耗时, 单位为纳秒
+	handled atomic.Uint64 // 处理的消息数
+	stolen  atomic.Uint64 // 从其他 worker 窃取的消息数
+	starved atomic.Uint64 // 为防止饿死而先于高优先级消息处理低优先级消息的次数
+}
+
+// utilization 返回 worker 自统计开始以来处理消息的时间占比
+func (s *workerStats) utilization() float64 {
+	elapsed := time.Since(s.since)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.busy.Load()) / float64(elapsed)
+}
+
+// WithUnordered 声明路由的处理逻辑不依赖同一连接内消息的顺序. DispatchHash 模式下这类消息
+// 不固定交给 ConnID 对应的 worker, 空闲的 worker 可以窃取它们, 从而平衡各 worker 的负载
+func WithUnordered() ziface.RouteOption {
+	return func(info *ziface.RouteInfo) {
+		info.Unordered = true
+	}
+}
+
+// StartWorkerPool 按 WorkerPoolSize 启动 worker 工作池, 工作池已经启动 (如配置热更新时调整过大小) 时不做处理.
+// DispatchTick 模式下同时启动 tick goroutine
+func (mh *MsgHandle) StartWorkerPool() {
+	if mh.dispatchMode == DispatchTick {
+		mh.startTick()
+	}
+	mh.poolLock.RLock()
+	started := mh.workers != nil
+	mh.poolLock.RUnlock()
+	if !started {
+		mh.SetWorkerPoolSize(mh.WorkerPoolSize)
+	}
+}
+
+// GetWorkerPoolSize 获取当前工作池的 worker 数量
+func (mh *MsgHandle) GetWorkerPoolSize() uint32 {
+	return mh.poolSize.Load()
+}
+
+// SetWorkerPoolSize 调整工作池的 worker 数量, 为 0 时每条消息启动一个 goroutine 处理, 返回时旧的 worker 均已退出.
+// 只在替换 worker 列表时持有 poolLock, 之后的分发立即进入新的队列, 因此调整期间分发不会暂停,
+// 处理链中再次分发消息也不会死锁. DispatchHash 模式下旧的 worker 处理完各自队列中剩余的消息后退出,
+// 新的 worker 等到旧的 worker 全部退出后才开始处理, 因此同一连接的消息仍保持顺序
+func (mh *MsgHandle) SetWorkerPoolSize(size uint32) {
+	mh.resizeLock.Lock()
+	defer mh.resizeLock.Unlock()
+
+	mh.poolLock.Lock()
+	old := mh.workers
+	if old != nil && len(old) == int(size) {
+		mh.poolLock.Unlock()
+		return
+	}
+	// 无序队列至少容纳一条消息, 否则放入消息时空闲的 worker 尚未被唤醒, 无法窃取
+	queueLen := max(settings.Conf.MaxWorkerTaskLen, 1)
+	workers := make([]*worker, size)
+	for i := range workers {
+		w := &worker{
+			id:    i,
+			queue: newLanes(queueLen),
+			local: newLanes(queueLen),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		if i < len(old) {
+			w.stats = old[i].stats
+		} else {
+			w.stats = &workerStats{since: time.Now()}
+		}
+		workers[i] = w
+	}
+	mh.workers = workers
+	mh.workerList.Store(&workers)
+	mh.poolSize.Store(size)
+	for _, w := range old {
+		close(w.stop)
+	}
+	mh.poolLock.Unlock()
+
+	ready := make(chan struct{})
+	for _, w := range workers {
+		if mh.dispatchMode == DispatchOrdered {
+			// mailbox 保证同一连接最多由一个 worker 处理, 新的 worker 可以立即开始
+			go mh.startOrderedWorker(w)
+		} else {
+			go mh.startWorker(w, ready)
+		}
+	}
+	if len(old) != len(workers) {
+		mh.logger.Info("worker pool resized", "from", len(old), "to", len(workers), "mode", mh.dispatchMode)
+	}
+
+	for _, w := range old {
+		<-w.done
+	}
+	close(ready)
+	// 工作池为空时不再有 worker 从运行队列中取出 mailbox, 交给独立的 goroutine 处理
+	if mh.dispatchMode == DispatchOrdered && size == 0 {
+		for len(mh.runQueue) > 0 {
+			go mh.drain(nil, <-mh.runQueue)
+		}
+		for mb := mh.popOverflow(); mb != nil; mb = mh.popOverflow() {
+			go mh.drain(nil, mb)
+		}
+	}
+}
+
+// workerOf 返回 DispatchHash 模式下负责处理消息的 worker, 工作池为空时返回 nil, 调用方须持有 poolLock
+func (mh *MsgHandle) workerOf(request ziface.IRequest) *worker {
+	if len(mh.workers) == 0 {
+		return nil
+	}
+	// 根据 ConnID 来分配当前的连接应该由哪个 worker 负责处理
+	// 每条消息都经过这里, 不记录日志: 即使日志级别高于 debug, 装箱参数也会产生内存分配
+	return mh.workers[request.GetConnection().GetConnID()%uint32(len(mh.workers))]
+}
+
+// taskQueue 返回 DispatchHash 模式下消息应放入的任务队列, 工作池为空时返回 nil, 调用方须持有 poolLock
+func (mh *MsgHandle) taskQueue(request ziface.IRequest, lane int, unordered bool) chan ziface.IRequest {
+	if w := mh.workerOf(request); w != nil {
+		return w.lane(lane, unordered)
+	}
+	return nil
+}
+
+// dispatch 在 DispatchHash 模式下将消息放入 worker 的 lane 通道, 队列已满时按背压策略处理.
+// 只在选择 worker 并尝试放入时持有 poolLock 读锁, pause 策略的等待不持有锁
+func (mh *MsgHandle) dispatch(request ziface.IRequest, lane int, unordered bool) {
+	mh.poolLock.RLock()
+	ch := mh.taskQueue(request, lane, unordered)
+	queued := false
+	if ch != nil {
+		select {
+		case ch <- request:
+			queued = true
+		default:
+		}
+	}
+	mh.poolLock.RUnlock()
+
+	if ch == nil {
+		// 没有工作池时, 从绑定好的消息和对应的处理方法中执行 Handle 方法
+		go mh.DoMsgHandler(request)
+		return
+	}
+	if !queued {
+		queued = mh.queueFull(request, func() bool { return mh.waitWorker(request, lane, unordered) })
+	}
+	if queued && unordered {
+		mh.signalSteal()
+	}
+}
+
+// signalSteal 通知一个空闲的 worker 有可窃取的消息
+func (mh *MsgHandle) signalSteal() {
+	select {
+	case mh.stealSignal <- struct{}{}:
+	default:
+	}
+}
+
+// steal 按优先级从其他 worker 的无序任务队列中窃取一条消息, 没有可窃取的消息时返回 nil
+func (mh *MsgHandle) steal(thief *worker) ziface.IRequest {
+	workers := *mh.workerList.Load()
+	for lane := range laneCount {
+		for i := 1; i < len(workers); i++ {
+			victim := workers[(thief.id+i)%len(workers)]
+			select {
+			case request := <-victim.local[lane]:
+				if victim.local.len() > 0 {
+					// 还有剩余的消息, 继续唤醒其他空闲的 worker
+					mh.signalSteal()
+				}
+				thief.stats.stolen.Add(1)
+				return request
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// process 由 worker 处理一条消息并记录耗时, w 为 nil 时不记录
+func (mh *MsgHandle) process(w *worker, request ziface.IRequest) {
+	if w == nil {
+		mh.DoMsgHandler(request)
+		return
+	}
+	start := time.Now()
+	mh.DoMsgHandler(request)
+	w.stats.busy.Add(int64(time.Since(start)))
+	w.stats.handled.Add(1)
+}
+
+// startWorker 启动一个 DispatchHash 模式的 worker: 等到 ready 关闭, 即上一代 worker 全部退出后开始,
+// 按优先级处理自己的队列, 空闲时从其他 worker 窃取无序消息
+func (mh *MsgHandle) startWorker(w *worker, ready <-chan struct{}) {
+	defer close(w.done)
+	<-ready
+	mh.logger.Debug("worker started", "workerID", w.id)
+	for {
+		select {
+		case <-w.stop:
+			mh.retire(w)
+			return
+		default:
+		}
+
+		if request, starved := popLanes(&w.skipped, &w.queue, &w.local); request != nil {
+			if starved {
+				w.stats.starved.Add(1)
+			}
+			mh.process(w, request)
+			continue
+		}
+		if request := mh.steal(w); request != nil {
+			mh.process(w, request)
+			continue
+		}
+
+		// 没有可处理的消息, 阻塞等待任一通道的消息或窃取通知
+		var request ziface.IRequest
+		select {
+		case <-w.stop:
+			mh.retire(w)
+			return
+		case request = <-w.queue[laneHigh]:
+		case request = <-w.local[laneHigh]:
+		case request = <-w.queue[laneNormal]:
+		case request = <-w.local[laneNormal]:
+		case request = <-w.queue[laneLow]:
+		case request = <-w.local[laneLow]:
+		case <-mh.stealSignal:
+			continue
+		}
+		mh.process(w, request)
+	}
+}
+
+// retire 在 worker 被移出工作池后处理其队列中剩余的消息. 先等待 pause 策略下仍登记在该 worker 上的分发方离开,
+// 此后不会再有消息进入它的队列
+func (mh *MsgHandle) retire(w *worker) {
+	w.waiters.Wait()
+	for {
+		request, _ := popLanes(&w.skipped, &w.queue, &w.local)
+		if request == nil {
+			return
+		}
+		mh.process(w, request)
+	}
+}